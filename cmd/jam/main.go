@@ -41,35 +41,62 @@ func (v *opsvalue) String() string {
 	return fmt.Sprintf("%+v", *v.ops)
 }
 
+// listsvalue is a flag.Value that sets a list merge strategy, at a path when
+// it is in front, path=strategy. All is whether a strategy for all lists was
+// set.
+type listsvalue struct {
+	o   *jam.MergeOptions
+	all bool
+}
+
+func (v *listsvalue) Set(s string) error {
+	p := ""
+	if i := strings.LastIndex(s, "="); i >= 0 {
+		p, s = s[:i], s[i+1:]
+	}
+	ls, err := jam.ParseListStrategy(s)
+	if err != nil {
+		return err
+	}
+	if p == "" {
+		v.o.Lists, v.all = ls, true
+		return nil
+	}
+	if v.o.Paths == nil {
+		v.o.Paths = map[string]jam.ListStrategy{}
+	}
+	v.o.Paths[p] = ls
+	return nil
+}
+
+func (v *listsvalue) String() string {
+	return ""
+}
+
 var (
 	opout = func(j *jam.Jam, b *pretty.Buffer, p string) error {
-		if b.Len() == 0 {
+		if b.Len() == 0 && !encoded {
 			if err := openc(j, b, ""); err != nil {
 				return err
 			}
 		}
+		encoded = false
 
-		var w *os.File
-		switch p {
-		case "-":
-			w = os.Stdout
-		default:
-			f, err := os.Create(p)
-			if err != nil {
-				return err
-			}
-			defer f.Close()
-			w = f
+		w, err := out(p)
+		if err != nil {
+			return err
 		}
 		if terminal.IsTerminal(int(w.Fd())) {
 			return b.Format(w)
 		}
-		_, err := b.WriteTo(w)
+		_, err = b.WriteTo(w)
 		return err
 	}
 
 	openc = func(j *jam.Jam, b *pretty.Buffer, p string) error {
+		encoded = true
 		e := jam.NewEncoder(b)
+		yaml := false
 		switch {
 		case p == "j" || p == "json":
 			b.Json()
@@ -80,16 +107,67 @@ var (
 			}
 			b.Toml()
 			e = e.AsToml()
+		case p == "x" || p == "xml":
+			b.Xml()
+			e = e.AsXml()
+		case p == "jsonl" || p == "ndjson":
+			b.Json()
+			e = e.AsJsonLines()
+		case p == "c" || p == "csv" || p == "tsv":
+			b.Ugly()
+			if p == "tsv" {
+				e = e.AsTsv()
+			} else {
+				e = e.AsCsv()
+			}
+			// one table, the items of lists and other values a row each
+			rows := []interface{}{}
+			for _, v := range j.Values() {
+				if s, ok := v.([]interface{}); ok {
+					rows = append(rows, s...)
+					continue
+				}
+				if v != nil {
+					rows = append(rows, v)
+				}
+			}
+			return e.Encode(rows)
 		case p == "g" || p == "go":
 			b.Go()
 			e = e.AsGo()
 		case p == "s" || p == "struct":
 			b.Go()
 			e = e.AsStruct()
+		case p == "r" || p == "report":
+			b.Diff()
+			for _, d := range diffs {
+				for _, c := range jam.ChangesWith(d[0], d[1], lists) {
+					if _, err := fmt.Fprintln(b, c); err != nil {
+						return err
+					}
+				}
+			}
+			return nil
+		case p == "p" || p == "patch":
+			b.Json()
+			e = e.AsJson()
+			for _, d := range diffs {
+				if err := e.Encode(jam.PatchTree(jam.PatchWith(d[0], d[1], lists))); err != nil {
+					return err
+				}
+			}
+			return nil
 		default:
 			b.Yaml()
+			yaml = true
 		}
-		for _, v := range j.Values() {
+		for i, v := range j.Values() {
+			if d := j.Doc(i); yaml && d != nil {
+				v = d
+				if annotate {
+					v = d.Annotated()
+				}
+			}
 			if err := e.Encode(v); err != nil {
 				return err
 			}
@@ -109,6 +187,8 @@ var (
 			b.Json()
 		case strings.Contains(p, ".toml.") || strings.HasSuffix(p, ".toml"):
 			b.Toml()
+		case strings.Contains(p, ".xml.") || strings.HasSuffix(p, ".xml"):
+			b.Xml()
 		default:
 			b.Ugly()
 		}
@@ -123,54 +203,97 @@ var (
 	}
 
 	opmerg = func(j *jam.Jam, b *pretty.Buffer, p string) error {
-		vs, err := decode(source(p))
+		vs, err := decode(p)
 		if err != nil {
 			return fmt.Errorf("merge: %s", err)
 		}
-		j.Merge(vs...)
+		j.MergeWith(lists, vs...)
 		return nil
 	}
 
 	opdiff = func(j *jam.Jam, b *pretty.Buffer, p string) error {
-		vs, err := decode(source(p))
+		vs, err := decode(p)
 		if err != nil {
 			return fmt.Errorf("diff: %s", err)
 		}
-		j.Diff(vs...)
+		diffs, differs = diffs[:0], false
+		for i, v := range vs {
+			if d, ok := v.(*jam.Doc); ok {
+				v = d.Value()
+			}
+			// copies, later merges are in place
+			diffs = append(diffs, [2]interface{}{jam.Clone(j.Value(i)), jam.Clone(v)})
+			if exitCode && !jam.Equal(j.Value(i), v) {
+				differs = true
+			}
+		}
+		j.DiffWith(lists, vs...)
 		return nil
 	}
 
-	opflt = func(j *jam.Jam, b *pretty.Buffer, p string) error {
-		j.Filter(p)
+	oppatch = func(j *jam.Jam, b *pretty.Buffer, p string) error {
+		vs, err := decode(p)
+		if err != nil {
+			return fmt.Errorf("patch: %s", err)
+		}
+		for _, v := range vs {
+			ops, err := jam.ParsePatch(v)
+			if err != nil {
+				return fmt.Errorf("patch: %s", err)
+			}
+			if err := j.ApplyPatch(ops); err != nil {
+				return fmt.Errorf("patch: %s", err)
+			}
+		}
 		return nil
 	}
 
-	opflti = func(j *jam.Jam, b *pretty.Buffer, p string) error {
-		j.FilterI(p)
+	opmerg3 = func(j *jam.Jam, b *pretty.Buffer, p string) error {
+		var vs [3][]interface{}
+		for i, a := range flag.Args() {
+			var err error
+			if vs[i], err = decode(a); err != nil {
+				return fmt.Errorf("merge: %s", err)
+			}
+		}
+		conflicts = j.Merge3(vs[0], vs[1], vs[2])
 		return nil
 	}
 
+	opflt = func(j *jam.Jam, b *pretty.Buffer, p string) error {
+		return j.Filter(p)
+	}
+
+	opflti = func(j *jam.Jam, b *pretty.Buffer, p string) error {
+		return j.FilterI(p)
+	}
+
 	opfltr = func(j *jam.Jam, b *pretty.Buffer, p string) error {
-		j.FilterR(p)
-		return nil
+		return j.FilterR(p)
 	}
 
 	opfltir = func(j *jam.Jam, b *pretty.Buffer, p string) error {
-		j.FilterIR(p)
-		return nil
+		return j.FilterIR(p)
 	}
 
 	opqry = func(j *jam.Jam, b *pretty.Buffer, p string) error {
-		j.Query(p)
-		return nil
+		return j.Search(queries[p])
 	}
 )
 
+// named is an input with a name for decode errors, files have one already.
+type named struct {
+	io.ReadCloser
+	name string
+}
+
+func (n named) Name() string { return n.name }
+
 func source(s string) (io.ReadCloser, error) {
 	switch {
 	case s == "":
 	case s == "-":
-		return ioutil.NopCloser(os.Stdin), nil
+		return named{ioutil.NopCloser(os.Stdin), "-"}, nil
 	case s[0] == '@':
 		f, err := os.Open(s[1:])
 		if err != nil {
@@ -178,28 +301,155 @@ func source(s string) (io.ReadCloser, error) {
 		}
 		return f, nil
 	}
-	return ioutil.NopCloser(strings.NewReader(s)), nil
+	return named{ioutil.NopCloser(strings.NewReader(s)), "string"}, nil
+}
+
+// outs holds output files, each is created once and written many times.
+var outs = map[string]*os.File{}
+
+// out returns the output file for s, stdout for -.
+func out(s string) (*os.File, error) {
+	if s == "-" {
+		return os.Stdout, nil
+	}
+	if f, ok := outs[s]; ok {
+		return f, nil
+	}
+	f, err := os.Create(s)
+	if err != nil {
+		return nil, err
+	}
+	outs[s] = f
+	return f, nil
+}
+
+// formats are the input format hints.
+var formats = []string{"yaml", "yml", "json", "jsonl", "ndjson", "toml", "xml", "csv", "tsv"}
+
+// hint splits an input format hint, like yaml:@file, from the front of s. A
+// hint followed by a space is not a hint, "csv: x" is yaml. Without a hint
+// the format of a file is taken from its extension, if it is one of the
+// hints. Otherwise the format is detected.
+func hint(s string) (string, string) {
+	for _, h := range formats {
+		n := len(h) + 1
+		if strings.HasPrefix(s, h+":") && len(s) > n && s[n] != ' ' {
+			return h, s[n:]
+		}
+	}
+	if strings.HasPrefix(s, "@") {
+		ext := strings.TrimPrefix(filepath.Ext(s), ".")
+		for _, h := range formats {
+			if ext == h {
+				return h, s
+			}
+		}
+	}
+	return "", s
 }
 
-func decode(rc io.ReadCloser, err error) ([]interface{}, error) {
+func decode(s string) ([]interface{}, error) {
 	vs := []interface{}{}
+	err := each(s, func(v interface{}) error {
+		vs = append(vs, v)
+		return nil
+	})
+	return vs, err
+}
+
+// each calls fn with every value decoded from input s.
+func each(s string, fn func(interface{}) error) error {
+	h, s := hint(s)
+	rc, err := source(s)
 	if err != nil {
-		return vs, err
+		return err
 	}
 	defer rc.Close()
-	d := jam.NewDecoder(rc)
+	d, err := jam.NewDecoderFormat(h, rc)
+	if err != nil {
+		return err
+	}
+	d = d.UseNumber()
+	if !sorted {
+		d = d.KeepOrder()
+	}
+	if tags {
+		d = d.Tag("!env", jam.EnvTag).Tag("!file", jam.FileTag)
+	}
+	if annotate {
+		d = d.TrackOrigins()
+	}
 	for {
-		var v interface{}
-		err := d.Decode(&v)
+		var (
+			v   interface{}
+			err error
+		)
+		if docs {
+			doc := &jam.Doc{}
+			err = d.Decode(doc)
+			v = doc
+		} else {
+			err = d.Decode(&v)
+		}
 		if jam.IsNoMore(err) {
-			break
+			return nil
 		}
 		if err != nil {
-			return vs, err
+			return err
+		}
+		if err := fn(v); err != nil {
+			return err
+		}
+	}
+}
+
+// keeps reports whether ops only encode yaml, in which case yaml documents
+// keep their comments, key order and styles.
+func keeps(ops []op) bool {
+	for _, o := range ops {
+		if o.fn != &openc {
+			continue
+		}
+		switch o.p {
+		case "", "y", "yaml", "yml":
+		default:
+			return false
 		}
-		vs = append(vs, v)
 	}
-	return vs, nil
+	return true
+}
+
+// streams reports whether ops can run on one value at a time, in constant
+// memory. That is when the pipeline starts with a json lines merge, merges or
+// diffs nothing else, and does not encode toml which takes a single value.
+func streams(ops []op) bool {
+	if len(ops) == 0 || ops[0].fn != &opmerg {
+		return false
+	}
+	if h, _ := hint(ops[0].p); h != "jsonl" && h != "ndjson" {
+		return false
+	}
+	for _, o := range ops[1:] {
+		switch {
+		case o.fn == &opmerg || o.fn == &opdiff:
+			return false
+		case o.fn == &openc && (o.p == "t" || o.p == "toml"):
+			return false
+		}
+	}
+	return true
+}
+
+// run applies ops to j.
+func run(j *jam.Jam, ops []op) error {
+	var pb pretty.Buffer
+	for _, o := range ops {
+		f := *o.fn
+		if err := f(j, &pb, o.p); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 var opflags = []struct {
@@ -207,11 +457,12 @@ var opflags = []struct {
 	fn    *func(*jam.Jam, *pretty.Buffer, string) error
 	usage string
 }{
-	{"d", &opdiff, "diff `in`put (-, @file, string) (yaml, json, toml)"},
-	{"m", &opmerg, "merge `in`put (-, @file, string) (yaml, json, toml)"},
+	{"d", &opdiff, "diff `in`put ([format:](-, @file, string)) (yaml, json, jsonl, toml, xml, csv, tsv)"},
+	{"m", &opmerg, "merge `in`put ([format:](-, @file, string)) (yaml, json, jsonl, toml, xml, csv, tsv)"},
+	{"p", &oppatch, "json patch `in`put ([format:](-, @file, string)) (yaml, json, toml)"},
 	{"x", &opexec, "exec template `in`put to buffer (-, @file, string) (text/template)"},
 	{},
-	{"e", &openc, "`enc`ode to buffer (yaml, json, toml, go, struct)"},
+	{"e", &openc, "`enc`ode to buffer (yaml, json, jsonl, toml, xml, csv, tsv, go, struct, patch, report)"},
 	{"o", &opout, "write `out` buffer (-, file)"},
 	{},
 	{"f", &opflt, "`filt`er plain"},
@@ -225,6 +476,7 @@ func main() {
 	var (
 		h, x, v bool
 		ops     = []op{}
+		lv      = listsvalue{o: &lists}
 	)
 	for _, o := range opflags {
 		if o.fn != nil {
@@ -234,8 +486,20 @@ func main() {
 	flag.BoolVar(&h, "H", false, "")
 	flag.BoolVar(&x, "X", false, "")
 	flag.BoolVar(&v, "v", false, "")
+	flag.BoolVar(&tags, "t", false, "")
+	flag.BoolVar(&sorted, "s", false, "")
+	flag.Var(&lv, "l", "")
+	flag.BoolVar(&lists.Patch, "n", false, "")
+	flag.BoolVar(&lists.DeleteStrings, "delete-strings", false, "")
+	flag.BoolVar(&three, "3", false, "")
+	flag.BoolVar(&annotate, "a", false, "")
+	flag.BoolVar(&exitCode, "exit-code", false, "")
 	flag.Usage = usage
 	flag.Parse()
+	if lists.Patch && !lv.all {
+		// json merge patches replace lists
+		lists.Lists = jam.ListStrategy{Merge: jam.ListReplace}
+	}
 
 	switch {
 	case h:
@@ -253,6 +517,12 @@ func main() {
 	for i, arg := range flag.Args() {
 		pops[i] = op{p: arg, fn: &opmerg}
 	}
+	if three {
+		if flag.NArg() != 3 {
+			log.Fatal("Error: a three way merge takes base, ours and theirs")
+		}
+		pops = []op{{fn: &opmerg3}}
+	}
 	ops = append(pops, ops...)
 
 	i, o := true, true
@@ -260,7 +530,7 @@ func main() {
 		switch {
 		case op.fn == &opout:
 			o = false
-		case op.fn == &opmerg || op.fn == &opdiff:
+		case op.fn == &opmerg || op.fn == &opdiff || op.fn == &opmerg3:
 			i, o = false, true
 		default:
 			o = true
@@ -274,21 +544,68 @@ func main() {
 	}
 
 	log.SetFlags(0)
-	var (
-		pb pretty.Buffer
-		j  = &jam.Jam{}
-	)
+	docs = keeps(ops) && !sorted || annotate
 	for _, o := range ops {
-		f := *o.fn
-		if err := f(j, &pb, o.p); err != nil {
+		if o.fn != &opqry {
+			continue
+		}
+		q, err := jam.CompileQuery(o.p)
+		if err != nil {
 			log.Fatal("Error: ", err)
 		}
+		queries[o.p] = q
+	}
+	var err error
+	switch {
+	case streams(ops):
+		err = each(ops[0].p, func(v interface{}) error {
+			return run(jam.NewJam(v), ops[1:])
+		})
+		if err != nil {
+			err = fmt.Errorf("merge: %s", err)
+		}
+	default:
+		err = run(&jam.Jam{}, ops)
+	}
+	for _, f := range outs {
+		f.Close()
+	}
+	if err != nil {
+		log.Fatal("Error: ", err)
+	}
+	if len(conflicts) > 0 {
+		for _, c := range conflicts {
+			log.Print("Conflict: ", c)
+		}
+		os.Exit(1)
+	}
+	if differs {
+		os.Exit(1)
 	}
 }
 
 var (
 	arg0    = filepath.Base(os.Args[0])
 	version = "unknown"
+	tags    bool
+	sorted  bool
+	docs    bool
+	// queries compiled once, before the pipeline runs
+	queries = map[string]*jam.QueryExpr{}
+	// decoded inputs are not shared, they are merged in place
+	lists = jam.MergeOptions{InPlace: true}
+	// the tree and the input of each value of the last diff, for patches
+	diffs [][2]interface{}
+	// three way merge of the arguments, and its conflicts
+	three     bool
+	conflicts []jam.Conflict
+	// whether anything was encoded since the last output, which may be
+	// nothing, an empty report for example
+	encoded bool
+	// exit 1 when the last diff found differences
+	exitCode, differs bool
+	// comment yaml values with the file and line they were merged from
+	annotate bool
 )
 
 const (
@@ -297,6 +614,17 @@ const (
   -H	moar halps
   -X	les exemples
   -v	version
+  -t	yaml tags (!env, !file)
+  -s	sort keys, instead of source order
+  -n	null deletes keys in merges (json merge patch)
+  -3	three way merge of the arguments, base ours theirs (git merge driver)
+  -a	annotate yaml with the origin of each value (file:line, or file for toml, xml, csv)
+  --exit-code
+    	exit 1 when the last diff (-d) found differences
+  --delete-strings
+    	the string "!delete" deletes keys in merges, like the yaml tag
+  -l <list>
+    	list merge strategy ([path=](index, replace, append, prepend, union, key:<name>))
 
 `
 
@@ -318,18 +646,113 @@ Inputs (in):
 
   Merge (-m <in>) takes one input and merges it with the tree. The input
   will overwrite matching parts of the tree.  Input format may be yaml,
-  json or toml.  The format will be detected automatically.
+  json, toml or xml, the format will be detected automatically.  Or it may be
+  chosen, see below.
+
+  A key with the yaml tag !delete is deleted from the tree.  The string
+  "!delete" is a value like any other, unless --delete-strings makes it
+  delete its key too, for formats without tags like toml and json.  With
+  null deletes (-n) merges are json merge patches (RFC 7396), a key with the
+  value null is deleted too, and lists are replaced unless a list strategy
+  (-l) for all lists says otherwise.
+
+  Lists are merged item by item, by index, unless a list strategy (-l) or
+  null deletes (-n) say otherwise.  A strategy is index, replace, append, prepend, union, which
+  appends and leaves out duplicates, or key:<name>, which merges items that
+  have the same <name> and appends the others.  A strategy applies to all
+  lists, or to the lists at a path in front of it, path=strategy.  A path is
+  map keys separated by ".", "*" matches any key, list items add nothing.
+
+  	-l append
+  	-l spec.containers=key:name
+  	-l '*.ports=union'
 
   Diff (-d <in>) is the transpose of merge.  Only the parts of the input that
-  are not in the tree will remain.  Input format may be yaml, json or toml.
-  The format will be detected automatically.
+  are not in the tree will remain.  Input formats are the same as merge.
+  Lists merged by key (-l path=key:<name>) keep the items that are new or
+  changed.  Other lists keep what a merge by index needs: items are matched
+  by a longest common subsequence, an item changed in place is diffed, and
+  one inserted or moved is kept whole.
+
+  A three way merge (-3) takes three arguments, base, ours and theirs, in
+  place of the merges of the arguments.  Changes of both ours and theirs to
+  base are merged.  Where they change the same value in different ways, or
+  one deletes what the other changes, ours is kept, the conflict is reported
+  and the exit status is 1.  Maps are merged by key, lists by index when all
+  three are the same length.  As a git merge driver, for yaml files:
+
+  	git config merge.jam.driver 'jam -3 -o %%A @%%O @%%A @%%B'
+  	echo '*.yml merge=jam' >> .gitattributes
+
+  With --exit-code, the exit status is 1 when the last diff found the input
+  to differ from the tree.  Inputs in any formats that hold the same data do
+  not differ, whatever their key order, layout or number formats.
+
+  Patch (-p <in>) applies a json patch (RFC 6902) input to the tree, a list
+  of ops in any input format.  An op that fails, a failed test included, is
+  an error.
+
+  The input format is chosen by a hint in front of the input, one of yaml:,
+  yml:, json:, jsonl:, ndjson:, toml:, xml:, csv:, or tsv:.  A hint followed
+  by a space is not a hint, 'yaml: x' is yaml.  Files without a hint named
+  with one of those extensions, @file.toml for example, are that format.
+  Otherwise the format is detected.  Csv, tsv and json lines are never
+  detected.
+
+  	"yaml:@file"
+  	"json:-"
+  	"toml:" String
+
+  Csv and tsv header rows become keys and each following row a map.  Numbers,
+  true, and false are converted, empty fields are null.
+
+  Xml elements become maps keyed by child element name.  Attributes are keys
+  prefixed with "@", text is kept under "#text", and repeated elements become
+  lists.  An element with only text is a string.
+
+  Numbers are kept as they are written, integers and decimals of any size
+  and precision pass through unchanged.  Toml integers are 64 bit, a bigger
+  one is an error, and go syntax writes a number that an int64, uint64 or
+  float64 would change as a json.Number.  Queries compare and compute with
+  floats.
+
+  Toml and yaml datetimes, dates and times, and yaml binary (!!binary) keep
+  their type, they are written natively to toml, yaml and go.  Other encodings
+  write them as text, binary in base64.  Yaml has no time of day, it is a
+  string there.
+
+  Yaml anchors, aliases and merge keys (<<) are resolved.  With yaml tags
+  (-t), "!env NAME" is replaced by the environment variable NAME and
+  "!file path" by the content of the file at path.  Any other custom tag is an
+  error, but for "!delete", see merge.
 
   Exec (-x <in>) executes a go text template input against the tree.  Input
   format must be a valid go text template.  See https://godoc.org/text/template
 
 Encoding (enc):
-  Encoding (-e <enc>) writes yaml, json, toml, go, or struct to the output
-  buffer.  Values y, j, t, g, s, are also acceptable if you are feeling lazy.
+  Encoding (-e <enc>) writes yaml, json, toml, xml, csv, tsv, go, struct,
+  patch, or report to the output buffer.  Values y, j, t, x, c, g, s, p, r,
+  are also acceptable if you are feeling lazy.  Xml reverses the input
+  mapping, a tree that is not a map with a single element is wrapped in a
+  "jam" element, and keys that are not xml names are an error.  Csv and tsv
+  write one table of maps, the items of lists and other values a row each,
+  nested values flattened to dotted column names.  Empty maps have no row.
+  Jsonl writes one compact json value per line, list items a line each.
+  Patch writes the json patch from the tree to the input of the last diff,
+  instead of the diff.
+  Report writes a line for each leaf of the last diff that is added (+),
+  removed (-) or changed (~), and each list item that is moved (>), with its
+  json pointer path and values.  List items are matched by a longest common
+  subsequence, or by key where a list strategy (-l) merges them by key, so
+  an item put in front of a list is one item added.
+
+  Map keys are written in the order of the inputs, keys added by a merge
+  come after the keys that were there.  With sort (-s) keys are written in
+  sorted order.
+
+  When yaml is encoded, yaml inputs keep their comments, key order and
+  quoting, unless keys are sorted (-s).  Parts of the tree changed by merges,
+  filters or queries are written anew.
 
 Outputs (out):
   Output (-o <out>) goes to file or stdout (-). If nothing has been written
@@ -337,7 +760,9 @@ Outputs (out):
 
 Queries:
   Query (-q <query>) applies a JMESPath query to the tree. See
-  http://jmespath.org/
+  http://jmespath.org/  Queries are compiled before any input is read, a
+  query that is not valid is an error with the column of what is wrong, as
+  is a query that fails on the tree.
 
 Filters (filt):
   Queries extract from or otherwise transform the tree.  In contrast, filters
@@ -345,23 +770,58 @@ Filters (filt):
   syntax which will look familiar and is absolutely simple.
 
   Map keys are addressed by name.  Nested keys are separated by a dot ".",
-  a star "*" matches any key.
+  a star "*" matches any key.  A key with a dot, a bracket, "=", "|" or "&"
+  in it is quoted with double or single quotes, or the character is escaped
+  with a backslash "\".  A backslash escapes any character, in quotes too,
+  so "\*" is a key named star and "\\" is a backslash.
+
+  A key with a star "*" or a question mark "?" in it is a glob, the star
+  matches any characters and the question mark one, "env.AWS_*".  A key in
+  slashes is a regular expression, "/^x-/", with "\/" for a slash.  Quoted
+  keys are neither.
 
   	MapKey "." NestedMapKey
   	"*" "." MapKey
+  	'"' QuotedMapKey '"' "." MapKey
+  	"'" QuotedMapKey "'" "." MapKey
+  	GlobMapKey "." MapKey
+  	"/" RegularExpression "/" "." MapKey
 
-  Lists are addressed with standard index notation.  Lists can be sliced;
-  slice notation plays by Go rules.
+  Lists are addressed with standard index notation.  Lists can be sliced,
+  with an optional step; slice notation plays by python rules.  Negative
+  indices count from the end, "[-1]" is the last item, and a slice takes
+  every step'th item, "[::2]" is every other item.  Bounds out of range are
+  clamped, an index out of range matches nothing.
 
   	"[]"
   	"[" Index "]"
-  	"[" GEIndex? ":" LTIndex? "]"
+  	"[" Start? ":" Stop? (":" Step?)? "]"
 
-  A filter query can match a specific value, which follows a "==" and may
-  only appear once at the end of the query string.  The value is decoded
-  from yaml, json or toml.
+  A filter query can end in a predicate on the value it matches, an operator
+  and a value.  The value of "==", "!=", "<", "<=", ">" and ">=" is decoded
+  from yaml, json or toml, numbers compare by value and strings in byte
+  order.  The value of "=~" is a regular expression strings match, and the
+  value of ":" is a type: string, number, bool, null, map or list.
 
   	"==" Value(json, yaml, toml)
+  	("!=" | "<" | "<=" | ">" | ">=") Value(json, yaml, toml)
+  	"=~" RegularExpression
+  	":" ("string" | "number" | "bool" | "null" | "map" | "list")
+
+  List items can be selected by a filter query in brackets, from the item,
+  which ends in a predicate.  The items it finds something in are selected,
+  and the query goes on from them, "containers[name==web].image" is the
+  image of the web container.
+
+  	"[" Filter "]"
+
+  Filter queries can be joined: a union "|" matches what either matches, an
+  intersection "&" what both match from the same place.  An intersection
+  binds tighter, "a|b&c" is "a" or "b" and "c".  A value with "|" or "&" in
+  it is quoted, or in parentheses as in "=~^(a|b)$".
+
+  	Filter "|" Filter
+  	Filter "&" Filter
 
   There are four filter behaviours.
 
@@ -396,6 +856,10 @@ Implicit Flags:
   have been written to the output buffer by the previous flags, encode as yaml
   (-e "yaml") is inserted before the output flag.
 
+  If the pipeline starts with a json lines merge and merges or diffs nothing
+  else, it is applied to each json value in turn.  Input is streamed and
+  memory use stays constant, however big the input.
+
   Putting all that together, invoking %[1]s with no flags or arguments is
   equivalent to
 
@@ -433,6 +897,24 @@ Convert toml to yaml:
   # output:
   # cute: blep
 
+Convert xml to json:
+  %[1]s -m '<cute id="1">blep</cute>' -e json
+
+  # implied: %[1]s -m '<cute id="1">blep</cute>' -e json -o -
+  # output:
+  # {"cute":{"#text":"blep","@id":"1"}}
+
+Convert csv to yaml:
+  %[1]s -m csv:@blep.csv
+
+  # implied: %[1]s -m csv:@blep.csv -e yaml -o -
+  # input:
+  # name,age
+  # blep,3
+  # output:
+  # - age: 3
+  #   name: blep
+
 Merge:
   %[1]s -m '{"blep":2,"mlem":6}' -m '{"blep":4}' -e json
 
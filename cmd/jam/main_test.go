@@ -0,0 +1,70 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/tr-d/jam"
+	"github.com/tr-d/jam/pretty"
+)
+
+func TestExitCode(t *testing.T) {
+	defer func(e, d bool) { exitCode, docs = e, d }(exitCode, docs)
+	var ss = []struct {
+		a, b    string
+		docs, x bool
+	}{
+		{`{"a":1}`, `{"a":1}`, true, false},
+		{`{"a":1}`, `{"a":1}`, false, false},
+		{"a: 1 # one\n", "a: 1\n", true, false},
+		{`{"a":1}`, `{"a":2}`, true, true},
+		{`{"a":1}`, `{"a":2}`, false, true},
+	}
+	for _, s := range ss {
+		exitCode, docs = true, s.docs
+		err := run(&jam.Jam{}, []op{{p: s.a, fn: &opmerg}, {p: s.b, fn: &opdiff}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if differs != s.x {
+			t.Errorf("%q %q docs %v: expected differs %v, got %v", s.a, s.b, s.docs, s.x, differs)
+		}
+	}
+}
+
+func TestTableOfValues(t *testing.T) {
+	var ss = []struct {
+		in, enc, x string
+	}{
+		{"jsonl:{\"a\":1}\n{\"a\":2,\"b\":3}\n{}\n", "csv", "a,b\n1,\n2,3\n"},
+		{"---\n- a: 1\n- a: 2\n---\na: 3\n", "tsv", "a\n1\n2\n3\n"},
+	}
+	for _, s := range ss {
+		j := &jam.Jam{}
+		if err := run(j, []op{{p: s.in, fn: &opmerg}}); err != nil {
+			t.Fatal(err)
+		}
+		var b pretty.Buffer
+		if err := openc(j, &b, s.enc); err != nil {
+			t.Fatal(err)
+		}
+		if b.String() != s.x {
+			t.Errorf("%q: expected %q, got %q", s.in, s.x, b.String())
+		}
+	}
+}
+
+func TestDiffsKept(t *testing.T) {
+	j := &jam.Jam{}
+	ops := []op{
+		{p: `{"a":1}`, fn: &opmerg},
+		{p: `{"a":1,"b":{"c":1}}`, fn: &opdiff},
+		{p: `{"b":{"c":2}}`, fn: &opmerg},
+	}
+	if err := run(j, ops); err != nil {
+		t.Fatal(err)
+	}
+	x := []interface{}{map[string]interface{}{"a": 1.0}, map[string]interface{}{"a": 1.0, "b": map[string]interface{}{"c": 1.0}}}
+	if len(diffs) != 1 || !jam.Equal(diffs[0][0], x[0]) || !jam.Equal(diffs[0][1], x[1]) {
+		t.Errorf("expected diffs %v, got %v", x, diffs)
+	}
+}
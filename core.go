@@ -1,232 +1,615 @@
 package jam
 
 import (
+	"encoding/json"
 	"fmt"
 	"io"
+	"math"
+	"math/big"
 	"reflect"
-	"regexp"
 	"sort"
 	"strconv"
-	"strings"
+	"time"
 	"unicode"
-
-	jmespath "github.com/jmespath/go-jmespath"
 )
 
 // Merge outputs the union of a and b with preference to b on matching keys.
+// Keys new to a are added in the order of b. The result is a *Map when a or
+// b is. Lists are merged by index, see MergeWith for other strategies. A key
+// of b with the value Delete is deleted from a. The result is a new tree, a
+// and b are unchanged, see MergeInPlace.
 func Merge(a, b interface{}) interface{} {
-	if reflect.TypeOf(a) != reflect.TypeOf(b) {
-		return b
-	}
-	switch b := b.(type) {
-	case map[string]interface{}:
-		a := a.(map[string]interface{})
-		for k, v := range b {
-			if u, ok := a[k]; ok {
-				a[k] = Merge(u, v)
-				continue
-			}
-			a[k] = v
-		}
-		return a
-	case []interface{}:
-		a := a.([]interface{})
-		for k, v := range b {
-			if k < len(a) {
-				a[k] = Merge(a[k], v)
-				continue
-			}
-			a = append(a, v)
-		}
-		return a
-	default:
-		return b
-	}
+	return MergeWith(a, b, MergeOptions{})
 }
 
-// Diff ouputs c that satisfies Merge(a, c) == b. Transpose of Merge.
+// Diff ouputs c that satisfies Merge(a, c) == b. Transpose of Merge. List
+// items are matched like Changes matches them, and merged by index: an item
+// left in place is its diff, one inserted or moved is whole, and the items
+// after the last change are left out. The result is a new tree, see
+// DiffInPlace.
 func Diff(a, b interface{}) interface{} {
-	v, t := diff(a, b)
-	if t {
+	return DiffWith(a, b, MergeOptions{})
+}
+
+// DiffInPlace is Diff, with a result that shares parts with a and b, which is
+// faster. Neither a nor b is changed.
+func DiffInPlace(a, b interface{}) interface{} {
+	return DiffWith(a, b, MergeOptions{InPlace: true})
+}
+
+// DiffWith outputs c that satisfies MergeWith(a, c, opts) == b, as far as c
+// can. Lists merged by key hold the items of b that are new or changed, with
+// their key, items of a that b removes are not there. Other lists are like
+// Diff. The result is a new tree unless opts are InPlace.
+func DiffWith(a, b interface{}, opts MergeOptions) interface{} {
+	v, t := merger(opts).diff(a, b, nil)
+	switch {
+	case t:
 		return nil
+	case opts.InPlace:
+		return v
 	}
-	return v
+	return Clone(v)
 }
 
 // diff is the actual implementation of Diff which requires additional returns
-// to work properly.
-func diff(a, b interface{}) (o interface{}, t bool) {
-	if reflect.TypeOf(a) != reflect.TypeOf(b) {
-		return b, false
-	}
-	if reflect.DeepEqual(a, b) {
+// to work properly. Path is the map keys to a.
+func (m merger) diff(a, b interface{}, path []string) (o interface{}, t bool) {
+	if equal(a, b) {
 		return b, true
 	}
-	switch b := b.(type) {
-	case map[string]interface{}:
-		a := a.(map[string]interface{})
-		c := make(map[string]interface{}, len(b))
-		for k, v := range b {
-			if u, ok := a[k]; ok {
-				if o, t := diff(u, v); !t {
-					c[k] = o
+	if ma, mb, ok := maps(a, b); ok {
+		c := NewMap()
+		for _, k := range mb.ks {
+			v := mb.m[k]
+			if u, ok := ma.m[k]; ok {
+				if o, t := m.diff(u, v, append(path[:len(path):len(path)], k)); !t {
+					c.Set(k, o)
 				}
 				continue
 			}
-			c[k] = v
+			c.Set(k, v)
+		}
+		if isOrdered(b) {
+			return c, false
 		}
-		return c, false
+		return c.m, false
+	}
+	if reflect.TypeOf(a) != reflect.TypeOf(b) {
+		return b, false
+	}
+	switch b := b.(type) {
 	case []interface{}:
 		a := a.([]interface{})
-		c := make([]interface{}, len(b))
-		fill := false
-		for i := len(b) - 1; i >= 0; i-- {
-			if i >= len(a) {
-				c[i] = b[i]
-				fill = true
-				continue
-			}
-			o, t := diff(a[i], b[i])
-			switch {
-			case !t:
-				fill = true
-				c[i] = o
-			case fill:
-				c[i] = a[i]
-			default:
-				c = c[:i]
-			}
+		if k := m.key(path); k != "" {
+			return m.diffKeyed(a, b, k, path), false
 		}
-		return c, !fill
+		c := m.diffList(a, b, path)
+		return c, len(c) == 0
 	default:
 		return b, false
 	}
 }
 
-type filterer struct {
-	i, r bool
-	p    string
+// diffList returns the items of list b merged by index into list a, up to
+// the last that changes it. An item equal to the item of a at its index is
+// that item. Other items are matched like Changes matches them, an item
+// changed in place is its diff, and one inserted or moved is whole.
+func (m merger) diffList(a, b []interface{}, path []string) []interface{} {
+	is := make(map[int]int, len(b))
+	for _, p := range match(a, b, "") {
+		is[p.b] = p.a
+	}
+	c, n := make([]interface{}, len(b)), 0
+	for j, v := range b {
+		if j < len(a) && equal(a[j], v) {
+			c[j] = a[j]
+			continue
+		}
+		if i, ok := is[j]; ok && i == j {
+			v, _ = m.diff(a[i], v, path)
+		}
+		c[j], n = v, j+1
+	}
+	return c[:n]
 }
 
-func (f filterer) filter(v interface{}, path string) (interface{}, bool) {
-	if path == "" {
-		if f.i {
-			return nil, false
+// diffKeyed returns the items of list b that are new or changed from list a,
+// items with the same value of key k being the same. A changed map item is
+// its diff, with key k in front.
+func (m merger) diffKeyed(a, b []interface{}, k string, path []string) []interface{} {
+	c, ps := []interface{}{}, map[int]int{}
+	for _, p := range match(a, b, k) {
+		ps[p.b] = p.a
+	}
+	for j, v := range b {
+		i, ok := ps[j]
+		if !ok {
+			c = append(c, v)
+			continue
 		}
-		return v, true
+		o, t := m.diff(a[i], v, path)
+		if t {
+			continue
+		}
+		mo, _ := toMap(o)
+		mv, _ := toMap(v)
+		x, _ := mv.Get(k)
+		u := NewMap()
+		u.Set(k, x)
+		for _, k := range mo.ks {
+			u.Set(k, mo.m[k])
+		}
+		if isOrdered(o) {
+			c = append(c, u)
+			continue
+		}
+		c = append(c, u.m)
 	}
-	if u, _, ok := nextValue(path); ok {
-		t := reflect.DeepEqual(u, v)
-		switch {
-		case t && f.i:
-			return nil, false
-		case t:
-			return v, true
+	return c
+}
+
+// Absent is the value of a Change or a Conflict that is not there, the old
+// value of a leaf that is added for example. No value of a tree is Absent.
+var Absent interface{} = absent{}
+
+// absent is the type of Absent.
+type absent struct{}
+
+// Conflict is a part of a three way merge that ours and theirs changed in
+// different ways. Path is a json pointer to it. A value that is deleted, or
+// that is not there, is Absent.
+type Conflict struct {
+	Path               string
+	Base, Ours, Theirs interface{}
+}
+
+// String describes the conflict, values as json.
+func (c Conflict) String() string {
+	return fmt.Sprintf("%q: base %s, ours %s, theirs %s", c.Path, brief(c.Base), brief(c.Ours), brief(c.Theirs))
+}
+
+// brief returns v as compact json for a message, Absent is "deleted".
+func brief(v interface{}) string {
+	if v == Absent {
+		return "deleted"
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(b)
+}
+
+// Change is a leaf that differs between two trees. Path is a json pointer to
+// it, in the new tree, or the old one for a leaf that is removed. Old is
+// Absent for a leaf that is added, New for one that is removed. A list item
+// that is moved has the pointer it is moved From, and the item before and
+// after, any changes to it follow.
+type Change struct {
+	Path     string
+	From     string
+	Old, New interface{}
+}
+
+// String describes the change in a line, "+" added, "-" removed, "~"
+// changed, ">" moved, values as json.
+func (c Change) String() string {
+	switch {
+	case c.From != "":
+		return fmt.Sprintf("> %q -> %q: %s", c.From, c.Path, brief(c.Old))
+	case c.Old == Absent:
+		return fmt.Sprintf("+ %q: %s", c.Path, brief(c.New))
+	case c.New == Absent:
+		return fmt.Sprintf("- %q: %s", c.Path, brief(c.Old))
+	}
+	return fmt.Sprintf("~ %q: %s -> %s", c.Path, brief(c.Old), brief(c.New))
+}
+
+// Changes outputs the leaves added, removed and changed from a to b, in the
+// order of the keys of b and then a. Maps are compared by key. Lists are
+// compared by a longest common subsequence, equal items match and items left
+// in the same place are changed, others are inserted, removed or moved. A
+// value that changes kind, a map to a list for example, is one change. It is
+// empty when a and b are equal, whatever their formats.
+func Changes(a, b interface{}) []Change {
+	return ChangesWith(a, b, MergeOptions{})
+}
+
+// ChangesWith outputs the changes from a to b like Changes. The items of
+// lists that opts merge by key match by key, other options are ignored.
+func ChangesWith(a, b interface{}, opts MergeOptions) []Change {
+	return merger(opts).changes(a, b, "", nil, []Change{})
+}
+
+// changes appends the changes from a to b at pointer p to cs, path is the
+// map keys to a. A value that is not there is Absent.
+func (m merger) changes(a, b interface{}, p string, path []string, cs []Change) []Change {
+	if equal(a, b) {
+		return cs
+	}
+	at := func(k string) []string { return append(path[:len(path):len(path)], k) }
+	if ma, mb, ok := maps(a, b); ok {
+		for _, k := range mb.ks {
+			u, ok := ma.m[k]
+			if !ok {
+				u = Absent
+			}
+			cs = m.changes(u, mb.m[k], p+"/"+escape(k), at(k), cs)
 		}
+		for _, k := range ma.ks {
+			if _, ok := mb.m[k]; !ok {
+				cs = m.changes(ma.m[k], Absent, p+"/"+escape(k), at(k), cs)
+			}
+		}
+		return cs
 	}
-	switch v := v.(type) {
-	case map[string]interface{}:
-		key, path, _ := nextKey(path)
-		o := map[string]interface{}{}
-		for k, v := range v {
-			switch {
-			case key == "*" || key == k:
-				if tmp, ok := f.filter(v, path); ok {
-					o[k] = tmp
-				}
-			case f.r:
-				if tmp, ok := f.filter(v, f.p); ok {
-					o[k] = tmp
-				}
-			case f.i:
-				o[k] = v
+	sa, aok := a.([]interface{})
+	sb, bok := b.([]interface{})
+	if aok && bok {
+		ps, seen := match(sa, sb, m.key(path)), map[int]bool{}
+		mv, is := moved(ps), map[int]int{}
+		for _, q := range ps {
+			is[q.b], seen[q.a] = q.a, true
+		}
+		for j, v := range sb {
+			pj := p + "/" + strconv.Itoa(j)
+			i, ok := is[j]
+			if !ok {
+				cs = m.changes(Absent, v, pj, path, cs)
+				continue
+			}
+			if mv[j] {
+				cs = append(cs, Change{Path: pj, From: p + "/" + strconv.Itoa(i), Old: sa[i], New: v})
 			}
+			cs = m.changes(sa[i], v, pj, path, cs)
 		}
-		return o, f.i || len(o) > 0
-	case []interface{}:
-		lb, ub, path, ok := nextSlice(path, len(v))
-		o := []interface{}{}
-		for i := 0; i < len(v); i++ {
-			switch {
-			case f.r && i >= lb && i < ub:
-				a, aok := f.filter(v[i], path)
-				b, bok := f.filter(a, f.p)
-				switch {
-				case aok && bok:
-					o = append(o, b)
-				case aok:
-					o = append(o, a)
-				}
-			case i >= lb && i < ub:
-				if tmp, ok := f.filter(v[i], path); ok {
-					o = append(o, tmp)
-				}
-			case f.r && !ok:
-				if tmp, ok := f.filter(v[i], f.p); ok {
-					o = append(o, tmp)
+		for i, u := range sa {
+			if !seen[i] {
+				cs = m.changes(u, Absent, p+"/"+strconv.Itoa(i), path, cs)
+			}
+		}
+		return cs
+	}
+	// the leaves of a value that is added or removed
+	if a == Absent || b == Absent {
+		v, side := a, func(u interface{}) (interface{}, interface{}) { return u, Absent }
+		if a == Absent {
+			v, side = b, func(u interface{}) (interface{}, interface{}) { return Absent, u }
+		}
+		if mv, ok := toMap(v); ok && mv.Len() > 0 {
+			for _, k := range mv.ks {
+				x, y := side(mv.m[k])
+				cs = m.changes(x, y, p+"/"+escape(k), at(k), cs)
+			}
+			return cs
+		}
+		if s, ok := v.([]interface{}); ok && len(s) > 0 {
+			for i, u := range s {
+				x, y := side(u)
+				cs = m.changes(x, y, p+"/"+strconv.Itoa(i), path, cs)
+			}
+			return cs
+		}
+	}
+	return append(cs, Change{Path: p, Old: a, New: b})
+}
+
+// Merge3 outputs base with the changes of both ours and theirs, a three way
+// merge. Where ours and theirs change the same value in different ways, or
+// one deletes what the other changes, ours is kept and a Conflict reported.
+// Maps are merged by key, lists by index when all three are the same length,
+// other values are changed by one side or they conflict. The result is a new
+// tree.
+func Merge3(base, ours, theirs interface{}) (interface{}, []Conflict) {
+	cs := []Conflict{}
+	v := merge3(base, ours, theirs, "", &cs)
+	if v == Absent {
+		v = nil
+	}
+	return Clone(v), cs
+}
+
+// merge3 is the actual implementation of Merge3, p is the pointer to the
+// values, which are Absent where they are not there.
+func merge3(base, ours, theirs interface{}, p string, cs *[]Conflict) interface{} {
+	switch {
+	case equal(ours, theirs), equal(base, theirs):
+		return ours
+	case equal(base, ours):
+		return theirs
+	}
+	if mo, mt, ok := maps(ours, theirs); ok {
+		mb, ok := toMap(base)
+		if !ok {
+			mb = NewMap()
+		}
+		m, seen := NewMap(), map[string]bool{}
+		for _, k := range append(mo.Keys(), mt.ks...) {
+			if seen[k] {
+				continue
+			}
+			seen[k] = true
+			at := func(m *Map) interface{} {
+				if v, ok := m.m[k]; ok {
+					return v
 				}
-			case f.i && (i < lb || i >= ub):
-				o = append(o, v[i])
+				return Absent
+			}
+			if v := merge3(at(mb), at(mo), at(mt), p+"/"+escape(k), cs); v != Absent {
+				m.Set(k, v)
 			}
 		}
-		return o, f.i || len(o) > 0
+		if isOrdered(ours) || isOrdered(theirs) {
+			return m
+		}
+		return m.m
 	}
-	if f.i {
-		return v, true
+	sb, bok := base.([]interface{})
+	so, ook := ours.([]interface{})
+	st, tok := theirs.([]interface{})
+	if bok && ook && tok && len(sb) == len(so) && len(so) == len(st) {
+		s := make([]interface{}, len(so))
+		for i := range so {
+			s[i] = merge3(sb[i], so[i], st[i], p+"/"+strconv.Itoa(i), cs)
+		}
+		return s
 	}
-	return nil, false
+	*cs = append(*cs, Conflict{Path: p, Base: base, Ours: ours, Theirs: theirs})
+	return ours
 }
 
 // Filter filters a structure according to the path.
 // Elements of v that do not match are removed.
 // The path must match from the root of v.
+// A path that does not compile matches nothing.
+//
+// Deprecated: a path that does not compile looks like one that matches
+// nothing. Use CompileFilter, which reports it, and FilterExpr.Filter.
 func Filter(v interface{}, path string) interface{} {
-	v, ok := filterer{}.filter(v, path)
-	if !ok {
+	e, err := CompileFilter(path)
+	if err != nil {
 		return nil
 	}
-	return v
+	return e.Filter(v)
 }
 
 // FilterI filters a structure according to the path. Inverted.
 // Elements of v that match are removed.
 // The path must match from the root of v.
+// A path that does not compile matches nothing, v is unchanged.
+//
+// Deprecated: a path that does not compile looks like one that matches
+// nothing. Use CompileFilter, which reports it, and FilterExpr.FilterI.
 func FilterI(v interface{}, path string) interface{} {
-	v, ok := filterer{i: true}.filter(v, path)
-	if !ok {
-		return nil
+	e, err := CompileFilter(path)
+	if err != nil {
+		return v
 	}
-	return v
+	return e.FilterI(v)
 }
 
 // FilterR filters a structure according to the path. Recursive.
 // Elements of v that do not match are removed.
 // The path may match at any depth in v.
+// A path that does not compile matches nothing.
+//
+// Deprecated: a path that does not compile looks like one that matches
+// nothing. Use CompileFilter, which reports it, and FilterExpr.FilterR.
 func FilterR(v interface{}, path string) interface{} {
-	v, ok := filterer{r: true, p: path}.filter(v, path)
-	if !ok {
+	e, err := CompileFilter(path)
+	if err != nil {
 		return nil
 	}
-	return v
+	return e.FilterR(v)
 }
 
 // FilterIR filters a structure according to the path. Inverted and Recursive.
 // Elements of v that match are removed.
 // The path may match at any depth in v.
+// A path that does not compile matches nothing, v is unchanged.
+//
+// Deprecated: a path that does not compile looks like one that matches
+// nothing. Use CompileFilter, which reports it, and FilterExpr.FilterIR.
 func FilterIR(v interface{}, path string) interface{} {
-	v, ok := filterer{i: true, r: true, p: path}.filter(v, path)
-	if !ok {
-		return nil
+	e, err := CompileFilter(path)
+	if err != nil {
+		return v
 	}
-	return v
+	return e.FilterIR(v)
 }
 
-// Query applies a jmespath search to v.
+// Query applies a jmespath search to v. Numbers are float64 and typed scalars
+// are text in the search, as they are in jmespath. A number of the result
+// that the search takes from a json.Number of v, rather than computes, is
+// that json.Number again.
+// A query that does not compile or fails is nil, see QueryE.
 func Query(v interface{}, s string) interface{} {
-	v, _ = jmespath.Search(s, v)
+	v, _ = QueryE(v, s)
 	return v
 }
 
+// Equal reports whether trees a and b are the same, whether Changes would
+// find none. Numbers are equal by value, whether they are json.Number or
+// float64, and maps whatever their order.
+func Equal(a, b interface{}) bool {
+	return equal(a, b)
+}
+
+// equal reports whether a and b are deeply equal. Numbers are equal by value,
+// whether they are json.Number or float64, and maps whatever their order.
+func equal(a, b interface{}) bool {
+	a, b = bare(a), bare(b)
+	if ma, ok := toMap(a); ok {
+		mb, ok := toMap(b)
+		if !ok || ma.Len() != mb.Len() {
+			return false
+		}
+		for k, v := range ma.m {
+			if u, ok := mb.m[k]; !ok || !equal(v, u) {
+				return false
+			}
+		}
+		return true
+	}
+	switch a := a.(type) {
+	case []interface{}:
+		b, ok := b.([]interface{})
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for i := range a {
+			if !equal(a[i], b[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	// numbers of the same type compare without big.Float
+	switch x := a.(type) {
+	case float64:
+		if y, ok := b.(float64); ok {
+			return x == y
+		}
+	case int:
+		if y, ok := b.(int); ok {
+			return x == y
+		}
+	case int64:
+		if y, ok := b.(int64); ok {
+			return x == y
+		}
+	case json.Number:
+		if y, ok := b.(json.Number); ok && x == y {
+			return true
+		}
+	}
+	if x, ok := number(a); ok {
+		y, ok := number(b)
+		return ok && x.Cmp(y) == 0
+	}
+	if x, ok := a.(time.Time); ok {
+		y, ok := b.(time.Time)
+		return ok && x.Equal(y)
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// number returns the value of a number. The precision is enough to tell
+// apart decimals of about 300 digits.
+func number(v interface{}) (*big.Float, bool) {
+	f := new(big.Float).SetPrec(1024)
+	switch v := v.(type) {
+	case json.Number:
+		_, ok := f.SetString(string(v))
+		return f, ok
+	case float64:
+		if math.IsNaN(v) {
+			return nil, false
+		}
+		return f.SetFloat64(v), true
+	case int64:
+		return f.SetInt64(v), true
+	case int:
+		return f.SetInt64(int64(v)), true
+	}
+	return nil, false
+}
+
+// Clone returns a deep copy of v, a tree of maps, *Map and lists. Binary
+// values are copied too, other values are immutable.
+func Clone(v interface{}) interface{} {
+	switch v := v.(type) {
+	case *Map:
+		m := &Map{ks: v.Keys(), m: make(map[string]interface{}, len(v.ks))}
+		for k, u := range v.m {
+			m.m[k] = Clone(u)
+		}
+		return m
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, u := range v {
+			m[k] = Clone(u)
+		}
+		return m
+	case []interface{}:
+		s := make([]interface{}, len(v))
+		for i, u := range v {
+			s[i] = Clone(u)
+		}
+		return s
+	case []byte:
+		return append([]byte{}, v...)
+	}
+	return v
+}
+
+// leaves returns v with each value that is not a map or a list replaced by
+// fn of the value.
+func leaves(v interface{}, fn func(interface{}) interface{}) interface{} {
+	switch v := v.(type) {
+	case *Map:
+		m := &Map{ks: v.Keys(), m: make(map[string]interface{}, len(v.ks))}
+		for k, u := range v.m {
+			m.m[k] = leaves(u, fn)
+		}
+		return m
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, u := range v {
+			m[k] = leaves(u, fn)
+		}
+		return m
+	case []interface{}:
+		s := make([]interface{}, len(v))
+		for i, u := range v {
+			s[i] = leaves(u, fn)
+		}
+		return s
+	}
+	return fn(v)
+}
+
+// native returns a json.Number as an int64 or uint64, or a float64 when it is
+// not an integer or too big. Other values are returned as they are.
+func native(v interface{}) interface{} {
+	n, ok := v.(json.Number)
+	if !ok {
+		return v
+	}
+	u, _ := exactly(n)
+	return u
+}
+
+// exactly returns n as an int64 or uint64, or a float64 when it is not an
+// integer or too big, and whether that is the same number. A float64 is
+// when its shortest decimal is, 0.1 for example.
+func exactly(n json.Number) (interface{}, bool) {
+	if i, err := n.Int64(); err == nil {
+		return i, true
+	}
+	if u, err := strconv.ParseUint(string(n), 10, 64); err == nil {
+		return u, true
+	}
+	f, err := n.Float64()
+	if err != nil {
+		return f, false
+	}
+	x, ok := number(n)
+	y, _ := number(json.Number(strconv.FormatFloat(f, 'g', -1, 64)))
+	return f, ok && x.Cmp(y) == 0
+}
+
+// float returns a json.Number as a float64, other values as they are.
+func float(v interface{}) interface{} {
+	n, ok := v.(json.Number)
+	if !ok {
+		return v
+	}
+	f, _ := n.Float64()
+	return f
+}
+
 // goStruct writes a go struct def to w
 func goStruct(w io.Writer, v interface{}) {
 	switch v := v.(type) {
@@ -282,6 +665,10 @@ func goStruct(w io.Writer, v interface{}) {
 
 	case nil:
 		io.WriteString(w, "interface{}")
+	case []byte:
+		io.WriteString(w, "[]byte")
+	case LocalDate, LocalTime, LocalDateTime, deletion:
+		io.WriteString(w, "string")
 	default:
 		fmt.Fprintf(w, "%T", v)
 	}
@@ -307,48 +694,3 @@ func goCase(s string) string {
 	}
 	return id
 }
-
-var (
-	nextKeyRe   = regexp.MustCompile(`^([^\.\[=]+)\.?`)
-	nextSliceRe = regexp.MustCompile(`^\[(\d*)(?:(:?)(\d*))?\]\.?`)
-	nextValueRe = regexp.MustCompile(`^==(.+)$`)
-)
-
-func nextKey(path string) (string, string, bool) {
-	ms := nextKeyRe.FindStringSubmatch(path)
-	if len(ms) == 0 {
-		return "", path, false
-	}
-	return ms[1], path[len(ms[0]):], true
-}
-
-func nextSlice(path string, length int) (int, int, string, bool) {
-	ms := nextSliceRe.FindStringSubmatch(path)
-	if len(ms) == 0 {
-		return 0, 0, path, false
-	}
-
-	var lb, ub int
-	switch {
-	case ms[3] == "" && (ms[1] == "" || ms[2] == ":"):
-		lb, _ = strconv.Atoi(ms[1])
-		ub = length
-	case ms[3] == "":
-		lb, _ = strconv.Atoi(ms[1])
-		ub = lb + 1
-	default:
-		lb, _ = strconv.Atoi(ms[1])
-		ub, _ = strconv.Atoi(ms[3])
-	}
-	return lb, ub, path[len(ms[0]):], true
-}
-
-func nextValue(path string) (interface{}, string, bool) {
-	var v interface{}
-	ms := nextValueRe.FindStringSubmatch(path)
-	if len(ms) == 0 {
-		return v, path, false
-	}
-	err := NewDecoder(strings.NewReader(ms[1])).Decode(&v)
-	return v, path[len(ms[0]):], err == nil
-}
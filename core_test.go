@@ -1,6 +1,7 @@
 package jam
 
 import (
+	"encoding/json"
 	"reflect"
 	"testing"
 )
@@ -24,6 +25,200 @@ func TestMerge(t *testing.T) {
 		if !reflect.DeepEqual(j.Value(0), s.x) {
 			t.Errorf("Expected %v, got %v", s.x, j.Value(0))
 		}
+		// a merge by index does not remove items
+		sa, _ := s.a.([]interface{})
+		if sb, ok := s.b.([]interface{}); ok && len(sa) <= len(sb) && !Equal(Merge(s.a, s.x), s.b) {
+			t.Errorf("%v to %v: expected the diff to merge to the second", s.a, s.b)
+		}
+	}
+}
+
+func TestChanges(t *testing.T) {
+	var ss = []struct {
+		a, b interface{}
+		x    []Change
+	}{
+		{_m{"a": json.Number("1")}, _m{"a": 1.0}, []Change{}},
+		{
+			_m{"a": 1.0, "b": _m{"c": 2.0, "d": _s{3.0}}, "e": "x"},
+			_m{"a": 2.0, "e": "x", "f": _m{"g": _s{}}},
+			[]Change{
+				{Path: "/a", Old: 1.0, New: 2.0},
+				{Path: "/f/g", Old: Absent, New: _s{}},
+				{Path: "/b/c", Old: 2.0, New: Absent},
+				{Path: "/b/d/0", Old: 3.0, New: Absent},
+			},
+		},
+		{
+			_s{1.0, 2.0},
+			_s{1.0, 3.0, _m{"a~b": nil}},
+			[]Change{
+				{Path: "/1", Old: 2.0, New: 3.0},
+				{Path: "/2/a~0b", Old: Absent, New: nil},
+			},
+		},
+		{_m{"a": _m{"b": 1.0}}, _m{"a": _s{1.0}}, []Change{{Path: "/a", Old: _m{"b": 1.0}, New: _s{1.0}}}},
+		{_s{1.0, 2.0, 3.0}, _s{0.0, 1.0, 2.0, 3.0}, []Change{{Path: "/0", Old: Absent, New: 0.0}}},
+		{_s{"a", "b", "c", "d"}, _s{"a", "c", "d"}, []Change{{Path: "/1", Old: "b", New: Absent}}},
+		{_m{"b": "keep"}, _m{"b": "!delete"}, []Change{{Path: "/b", Old: "keep", New: "!delete"}}},
+		{
+			_s{"a", "b", "c"},
+			_s{"c", "a", "x"},
+			[]Change{
+				{Path: "/0", From: "/2", Old: "c", New: "c"},
+				{Path: "/2", Old: Absent, New: "x"},
+				{Path: "/1", Old: "b", New: Absent},
+			},
+		},
+	}
+	for _, s := range ss {
+		if cs := Changes(s.a, s.b); !reflect.DeepEqual(cs, s.x) {
+			t.Errorf("%v to %v: expected %v, got %v", s.a, s.b, s.x, cs)
+		}
+	}
+	for c, x := range map[Change]string{
+		{Path: "/a", Old: Absent, New: "x"}:          `+ "/a": "x"`,
+		{Path: "/a", Old: 1.0, New: Absent}:          `- "/a": 1`,
+		{Path: "/a", Old: 1.0, New: 2.0}:             `~ "/a": 1 -> 2`,
+		{Path: "/0", From: "/2", Old: 1.0, New: 1.0}: `> "/2" -> "/0": 1`,
+	} {
+		if c.String() != x {
+			t.Errorf("expected %s, got %s", x, c.String())
+		}
+	}
+}
+
+func TestChangesKeyed(t *testing.T) {
+	a := _m{"c": _s{_m{"name": "a", "v": 1.0}, _m{"name": "b", "v": 2.0}, _m{"name": "c"}}}
+	b := _m{"c": _s{_m{"name": "d"}, _m{"name": "b", "v": 3.0}, _m{"name": "a", "v": 1.0}}}
+	opts := MergeOptions{Paths: map[string]ListStrategy{"c": {Merge: ListKey, Key: "name"}}}
+	x := []Change{
+		{Path: "/c/0/name", Old: Absent, New: "d"},
+		{Path: "/c/1/v", Old: 2.0, New: 3.0},
+		{Path: "/c/2", From: "/c/0", Old: _m{"name": "a", "v": 1.0}, New: _m{"name": "a", "v": 1.0}},
+		{Path: "/c/2/name", Old: "c", New: Absent},
+	}
+	if cs := ChangesWith(a, b, opts); !reflect.DeepEqual(cs, x) {
+		t.Errorf("expected %v, got %v", x, cs)
+	}
+}
+
+func TestDiffWith(t *testing.T) {
+	opts := MergeOptions{Paths: map[string]ListStrategy{"c": {Merge: ListKey, Key: "name"}}}
+	var ss = []struct {
+		a, b, x interface{}
+	}{
+		{
+			_m{"c": _s{_m{"name": "a", "v": 1.0}, _m{"name": "b", "v": 2.0, "w": 1.0}}},
+			_m{"c": _s{_m{"name": "b", "v": 3.0, "w": 1.0}, _m{"name": "a", "v": 1.0}, _m{"name": "c"}}},
+			_m{"c": _s{_m{"name": "b", "v": 3.0}, _m{"name": "c"}}},
+		},
+		{
+			_m{"c": _s{om("name", "a", "v", 1.0)}},
+			_m{"c": _s{om("v", 2.0, "name", "a")}},
+			_m{"c": _s{om("name", "a", "v", 2.0)}},
+		},
+		{_m{"c": _s{_m{"name": "a"}}}, _m{"c": _s{_m{"name": "a"}}}, nil},
+	}
+	for _, s := range ss {
+		if c := DiffWith(s.a, s.b, opts); !reflect.DeepEqual(c, s.x) {
+			t.Errorf("expected %v, got %v", s.x, c)
+		}
+	}
+}
+
+func TestMerge3(t *testing.T) {
+	var ss = []struct {
+		name              string
+		base, ours, their interface{}
+		x                 interface{}
+		cs                []Conflict
+	}{
+		{
+			"both sides",
+			_m{"a": 1.0, "b": 2.0, "c": 3.0},
+			_m{"a": 4.0, "b": 2.0, "c": 3.0},
+			_m{"a": 1.0, "b": 5.0},
+			_m{"a": 4.0, "b": 5.0},
+			[]Conflict{},
+		},
+		{
+			"same change",
+			_m{"a": 1.0},
+			_m{"a": 2.0, "b": 3.0},
+			_m{"a": 2.0, "b": 3.0},
+			_m{"a": 2.0, "b": 3.0},
+			[]Conflict{},
+		},
+		{
+			"changed differently",
+			_m{"a": _m{"b": 1.0}},
+			_m{"a": _m{"b": 2.0}},
+			_m{"a": _m{"b": 3.0}},
+			_m{"a": _m{"b": 2.0}},
+			[]Conflict{{Path: "/a/b", Base: 1.0, Ours: 2.0, Theirs: 3.0}},
+		},
+		{
+			"deleted and changed",
+			_m{"a": 1.0, "b": 1.0},
+			_m{"b": 1.0},
+			_m{"a": 2.0, "b": 1.0},
+			_m{"b": 1.0},
+			[]Conflict{{Path: "/a", Base: 1.0, Ours: Absent, Theirs: 2.0}},
+		},
+		{
+			"the string !delete",
+			_m{"a": 1.0, "b": 1.0},
+			_m{"a": "!delete", "b": 1.0},
+			_m{"a": 1.0, "b": 2.0},
+			_m{"a": "!delete", "b": 2.0},
+			[]Conflict{},
+		},
+		{
+			"added differently",
+			_m{},
+			_m{"a": _m{"b": 1.0}},
+			_m{"a": _m{"c": 2.0}, "d": 3.0},
+			_m{"a": _m{"b": 1.0, "c": 2.0}, "d": 3.0},
+			[]Conflict{},
+		},
+		{
+			"lists by index",
+			_m{"l": _s{1.0, _m{"a": 1.0}}},
+			_m{"l": _s{2.0, _m{"a": 1.0}}},
+			_m{"l": _s{1.0, _m{"a": 1.0, "b": 2.0}}},
+			_m{"l": _s{2.0, _m{"a": 1.0, "b": 2.0}}},
+			[]Conflict{},
+		},
+		{
+			"lists resized",
+			_m{"l": _s{1.0}},
+			_m{"l": _s{1.0, 2.0}},
+			_m{"l": _s{}},
+			_m{"l": _s{1.0, 2.0}},
+			[]Conflict{{Path: "/l", Base: _s{1.0}, Ours: _s{1.0, 2.0}, Theirs: _s{}}},
+		},
+		{
+			"ordered",
+			om("b", 1.0, "a", 1.0),
+			om("b", 1.0, "a", 1.0, "c", 2.0),
+			om("d", 3.0, "a", 1.0),
+			om("a", 1.0, "c", 2.0, "d", 3.0),
+			[]Conflict{},
+		},
+	}
+	for _, s := range ss {
+		v, cs := Merge3(s.base, s.ours, s.their)
+		if !reflect.DeepEqual(v, s.x) {
+			t.Errorf("%s: expected %v, got %v", s.name, s.x, v)
+		}
+		if !reflect.DeepEqual(cs, s.cs) {
+			t.Errorf("%s: expected conflicts %v, got %v", s.name, s.cs, cs)
+		}
+	}
+	c := Conflict{Path: "/a", Base: 1.0, Ours: Absent, Theirs: _m{"b": 2.0}}
+	if x := `"/a": base 1, ours deleted, theirs {"b":2}`; c.String() != x {
+		t.Errorf("expected %s, got %s", x, c.String())
 	}
 }
 
@@ -35,12 +230,29 @@ func TestDiff(t *testing.T) {
 		{_s{0, 1, 2}, _s{0, 1, 2, 3}, _s{0, 1, 2, 3}},
 		{_s{0, 1, 2}, _s{2, 3, 2}, _s{2, 3}},
 		{_s{0, 1, 2}, _s{0, 3, 2}, _s{0, 3}},
+		{
+			_s{_m{"n": "a", "v": 1.0}, _m{"n": "b", "v": 1.0}},
+			_s{_m{"n": "c", "v": 1.0}, _m{"n": "a", "v": 1.0}, _m{"n": "b", "v": 1.0}},
+			_s{_m{"n": "c", "v": 1.0}, _m{"n": "a", "v": 1.0}, _m{"n": "b", "v": 1.0}},
+		},
+		{
+			_s{_m{"n": "a", "v": 1.0}, _m{"n": "b", "v": 1.0}, _m{"n": "c", "v": 1.0}},
+			_s{_m{"n": "a", "v": 1.0}, _m{"n": "b", "v": 2.0}, _m{"n": "c", "v": 1.0}},
+			_s{_m{"n": "a", "v": 1.0}, _m{"v": 2.0}},
+		},
 		{0, false, false},
 		{false, true, true},
 		{_m{"foo": true}, _m{"foo": false}, _m{"foo": false}},
 		{_m{"foo": true}, _m{"baz": true}, _m{"baz": true}},
 		{_s{true, "foo"}, _s{false}, _s{false}},
 		{_s{}, _s{true}, _s{true}},
+		{json.Number("1"), 1.0, nil},
+		{json.Number("1.0"), json.Number("1"), nil},
+		{
+			_m{"a": json.Number("12345678901234567890"), "b": json.Number("2")},
+			_m{"a": json.Number("12345678901234567891"), "b": json.Number("2")},
+			_m{"a": json.Number("12345678901234567891")},
+		},
 	}
 
 	for _, s := range ss {
@@ -50,6 +262,11 @@ func TestDiff(t *testing.T) {
 		if !reflect.DeepEqual(j.Value(0), s.x) {
 			t.Errorf("Expected %v, got %v", s.x, j.Value(0))
 		}
+		// a merge by index does not remove items
+		sa, _ := s.a.([]interface{})
+		if sb, ok := s.b.([]interface{}); ok && len(sa) <= len(sb) && !Equal(Merge(s.a, s.x), s.b) {
+			t.Errorf("%v to %v: expected the diff to merge to the second", s.a, s.b)
+		}
 	}
 }
 
@@ -74,6 +291,21 @@ func TestFilter(t *testing.T) {
 		{"foo[1:]", _m{"foo": _s{1, 2, 3}}, _m{"foo": _s{2, 3}}},
 		{"foo[:2]", _m{"foo": _s{1, 2, 3}}, _m{"foo": _s{1, 2}}},
 		{"[].x", _s{_m{"x": 1, "y": "y"}, _m{"x": 2, "y": "x"}}, _s{_m{"x": 1}, _m{"x": 2}}},
+		{"[-1]", _s{1, 2, 3}, _s{3}},
+		{"[-3]", _s{1, 2, 3}, _s{1}},
+		{"[-4]", _s{1, 2, 3}, nil},
+		{"[3]", _s{1, 2, 3}, nil},
+		{"[-2:]", _s{1, 2, 3}, _s{2, 3}},
+		{"[:-1]", _s{1, 2, 3}, _s{1, 2}},
+		{"[-10:10]", _s{1, 2, 3}, _s{1, 2, 3}},
+		{"[::2]", _s{1, 2, 3, 4, 5}, _s{1, 3, 5}},
+		{"[1::2]", _s{1, 2, 3, 4, 5}, _s{2, 4}},
+		{"[1:4:2]", _s{1, 2, 3, 4, 5}, _s{2, 4}},
+		{"[::-2]", _s{1, 2, 3, 4, 5}, _s{1, 3, 5}},
+		{"[3:0:-1]", _s{1, 2, 3, 4, 5}, _s{2, 3, 4}},
+		{"[-1::-3]", _s{1, 2, 3, 4, 5}, _s{2, 5}},
+		{"[2:1]", _s{1, 2, 3}, nil},
+		{"foo[-1].x", _m{"foo": _s{_m{"x": 1}, _m{"x": 2, "y": 3}}}, _m{"foo": _s{_m{"x": 2}}}},
 		{"[1:2].x", _s{_m{"x": 1, "y": "y"}, _m{"x": 2, "y": "x"}}, _s{_m{"x": 2}}},
 		{
 			"[][]",
@@ -98,6 +330,77 @@ func TestFilter(t *testing.T) {
 		{"==1", float64(1), float64(1)},
 		{"[]==blep", _s{"blep", "mlem"}, _s{"blep"}},
 		{"*==mlem", _m{"0": "blep", "1": "mlem"}, _m{"1": "mlem"}},
+		{"[]==1.5", _s{json.Number("1.50"), json.Number("2")}, _s{json.Number("1.50")}},
+		{
+			"*==12345678901234567891",
+			_m{"a": json.Number("12345678901234567890"), "b": json.Number("12345678901234567891")},
+			_m{"b": json.Number("12345678901234567891")},
+		},
+		{
+			`labels."app.kubernetes.io/name"`,
+			_m{"labels": _m{"app.kubernetes.io/name": "blep", "app": "mlem"}},
+			_m{"labels": _m{"app.kubernetes.io/name": "blep"}},
+		},
+		{`'a.b'.c`, _m{"a.b": _m{"c": 1, "d": 2}, "a": 3}, _m{"a.b": _m{"c": 1}}},
+		{`"a[0]"[1]`, _m{"a[0]": _s{1, 2}, "a": _s{3}}, _m{"a[0]": _s{2}}},
+		{`"a=b"==1`, _m{"a=b": 1, "a": 1}, _m{"a=b": 1}},
+		{`"say \"hi\""`, _m{`say "hi"`: 1, "say": 2}, _m{`say "hi"`: 1}},
+		{`a\.b`, _m{"a.b": 1, "a": _m{"b": 2}}, _m{"a.b": 1}},
+		{`a\\b`, _m{`a\b`: 1, "ab": 2}, _m{`a\b`: 1}},
+		{`\*`, _m{"*": 1, "a": 2}, _m{"*": 1}},
+		{`"*"`, _m{"*": 1, "a": 2}, _m{"*": 1}},
+		{`""`, _m{"": 1, "a": 2}, _m{"": 1}},
+		{"spec.*Port", _m{"spec": _m{"httpPort": 1, "port": 2, "Port": 3}}, _m{"spec": _m{"httpPort": 1, "Port": 3}}},
+		{"env.AWS_*", _m{"env": _m{"AWS_REGION": 1, "AWS": 2, "HOME": 3}}, _m{"env": _m{"AWS_REGION": 1}}},
+		{"a?", _m{"ab": 1, "a": 2, "abc": 3}, _m{"ab": 1}},
+		{"é*", _m{"éa": 1, "ea": 2}, _m{"éa": 1}},
+		{"a.b*", _m{"a.b": 1, "a": _m{"bc": 2}}, _m{"a": _m{"bc": 2}}},
+		{`a\*`, _m{"a*": 1, "ab": 2}, _m{"a*": 1}},
+		{`"a*"`, _m{"a*": 1, "ab": 2}, _m{"a*": 1}},
+		{"/^x-.*/", _m{"x-a": 1, "y-x-b": 2, "info": 3}, _m{"x-a": 1}},
+		{"/x-/", _m{"x-a": 1, "y-x-b": 2, "info": 3}, _m{"x-a": 1, "y-x-b": 2}},
+		{"/^a.b$/.c", _m{"a.b": _m{"c": 1, "d": 2}, "axb": _m{"d": 3}}, _m{"a.b": _m{"c": 1}}},
+		{`/^a\/b$/`, _m{"a/b": 1, "ab": 2}, _m{"a/b": 1}},
+		{`/\d+/[0]`, _m{"a1": _s{1, 2}, "b": _s{3}}, _m{"a1": _s{1}}},
+		{"[]!=blep", _s{"blep", "mlem"}, _s{"mlem"}},
+		{"[]>2", _s{1, 2, 3, json.Number("4")}, _s{3, json.Number("4")}},
+		{"[]>=2", _s{1, 2, 3}, _s{2, 3}},
+		{"[]<2", _s{1, 2, 3}, _s{1}},
+		{"[]<=2", _s{1, 2, 3, "a"}, _s{1, 2}},
+		{"[]<b", _s{"a", "b", "c", 1}, _s{"a"}},
+		{"[]=~^b", _s{"blep", "mlem", "ab"}, _s{"blep"}},
+		{"[]:string", _s{"a", 1, true, nil, _m{}, _s{}}, _s{"a"}},
+		{"[]:number", _s{"a", 1, true, nil, _m{}, _s{}}, _s{1}},
+		{"[]:bool", _s{"a", 1, true, nil, _m{}, _s{}}, _s{true}},
+		{"[]:null", _s{"a", 1, true, nil, _m{}, _s{}}, _s{nil}},
+		{"[]:map", _s{"a", 1, true, nil, _m{}, _s{}}, _s{_m{}}},
+		{"[]:list", _s{"a", 1, true, nil, _m{}, _s{}}, _s{_s{}}},
+		{"a:b", _m{"a:b": 1, "a": 2}, _m{"a:b": 1}},
+		{"a!b", _m{"a!b": 1, "a": 2}, _m{"a!b": 1}},
+		{
+			"items[].status.replicas>3",
+			_m{"items": _s{_m{"status": _m{"replicas": 5, "ready": 5}}, _m{"status": _m{"replicas": 1}}}},
+			_m{"items": _s{_m{"status": _m{"replicas": 5}}}},
+		},
+		{
+			"containers[name==web].image",
+			_m{"containers": _s{_m{"name": "web", "image": "nginx"}, _m{"name": "db", "image": "pg"}}},
+			_m{"containers": _s{_m{"image": "nginx"}}},
+		},
+		{
+			"containers[name==web]",
+			_m{"containers": _s{_m{"name": "web", "image": "nginx"}, _m{"name": "db", "image": "pg"}}},
+			_m{"containers": _s{_m{"name": "web", "image": "nginx"}}},
+		},
+		{
+			"items[status.replicas>3].name",
+			_m{"items": _s{_m{"name": "a", "status": _m{"replicas": 5}}, _m{"name": "b", "status": _m{"replicas": 1}}}},
+			_m{"items": _s{_m{"name": "a"}}},
+		},
+		{"[image:number]", _s{_m{"image": 1}, _m{"name": 2}}, _s{_m{"image": 1}}},
+		{"[=~^v]", _s{"v1", "w2", "v3"}, _s{"v1", "v3"}},
+		{"[ports[0]==80]", _s{_m{"ports": _s{80}}, _m{"ports": _s{81, 80}}}, _s{_m{"ports": _s{80}}}},
+		{`[name=="a]b"]`, _s{_m{"name": "a]b"}, _m{"name": "c"}}, _s{_m{"name": "a]b"}}},
 	}
 
 	for _, s := range ss {
@@ -122,6 +425,15 @@ func TestFilterI(t *testing.T) {
 		{"[0]", _s{"a", "b", "c"}, _s{"b", "c"}},
 		{"[1]", _s{"a", "b", "c"}, _s{"a", "c"}},
 		{"foo", _m{"foo": true, "baz": true}, _m{"baz": true}},
+		{`"foo.baz"`, _m{"foo.baz": true, "foo": _m{"baz": true}}, _m{"foo": _m{"baz": true}}},
+		{"/^x-/", _m{"x-a": 1, "info": 2}, _m{"info": 2}},
+		{"*Port", _m{"httpPort": 1, "host": 2}, _m{"host": 2}},
+		{
+			"containers[name==web]",
+			_m{"containers": _s{_m{"name": "web"}, _m{"name": "db"}}},
+			_m{"containers": _s{_m{"name": "db"}}},
+		},
+		{"[]>1", _s{1, 2, 3}, _s{1}},
 		{"*", _m{"foo": true, "baz": true}, _m{}},
 		{"foo[]", _m{"foo": _s{1, 2, 3}}, _m{"foo": _s{}}},
 		{"foo[:]", _m{"foo": _s{1, 2, 3}}, _m{"foo": _s{}}},
@@ -129,6 +441,9 @@ func TestFilterI(t *testing.T) {
 		{"foo[2]", _m{"foo": _s{1, 2, 3}}, _m{"foo": _s{1, 2}}},
 		{"foo[1:]", _m{"foo": _s{1, 2, 3}}, _m{"foo": _s{1}}},
 		{"foo[:2]", _m{"foo": _s{1, 2, 3}}, _m{"foo": _s{3}}},
+		{"foo[-1]", _m{"foo": _s{1, 2, 3}}, _m{"foo": _s{1, 2}}},
+		{"foo[::2]", _m{"foo": _s{1, 2, 3}}, _m{"foo": _s{2}}},
+		{"foo[-9]", _m{"foo": _s{1, 2, 3}}, _m{"foo": _s{1, 2, 3}}},
 		{"[].x", _s{_m{"x": 1, "y": "y"}, _m{"x": 2, "y": "x"}}, _s{_m{"y": "y"}, _m{"y": "x"}}},
 		{"[1:2].x", _s{_m{"x": 1, "y": "y"}, _m{"x": 2, "y": "x"}}, _s{_m{"x": 1, "y": "y"}, _m{"y": "x"}}},
 		{
@@ -256,6 +571,11 @@ func TestFilterIR(t *testing.T) {
 		{"foo[2]", _m{"foo": _s{1, 2, 3}}, _m{"foo": _s{1, 2}}},
 		{"foo[1:]", _m{"foo": _s{1, 2, 3}}, _m{"foo": _s{1}}},
 		{"foo[:2]", _m{"foo": _s{1, 2, 3}}, _m{"foo": _s{3}}},
+		{
+			"/^x-/",
+			_m{"x-a": 1, "paths": _m{"/p": _m{"x-b": 2, "get": _s{_m{"x-c": 3, "d": 4}}}}},
+			_m{"paths": _m{"/p": _m{"get": _s{_m{"d": 4}}}}},
+		},
 		{"[].x", _s{_m{"x": 1, "y": "y"}, _m{"x": 2, "y": "x"}}, _s{_m{"y": "y"}, _m{"y": "x"}}},
 		{"[1:2].x", _s{_m{"x": 1, "y": "y"}, _m{"x": 2, "y": "x"}}, _s{_m{"x": 1, "y": "y"}, _m{"y": "x"}}},
 		{
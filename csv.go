@@ -0,0 +1,192 @@
+package jam
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// numberRe matches json numbers, which excludes leading zeros like "007".
+var numberRe = regexp.MustCompile(`^-?(?:0|[1-9]\d*)(?:\.\d+)?(?:[eE][+-]?\d+)?$`)
+
+// decodeTable decodes a table of records from b, fields are separated by
+// comma. The header row becomes keys and each following row a map. When infer
+// is true, numbers and booleans are converted and empty fields become nil.
+func decodeTable(b []byte, comma rune, infer bool) (interface{}, error) {
+	r := csv.NewReader(bytes.NewReader(b))
+	r.Comma = comma
+	head, err := r.Read()
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	seen := map[string]bool{}
+	for i, k := range head {
+		if seen[k] {
+			l, c := r.FieldPos(i)
+			return nil, &csv.ParseError{StartLine: l, Line: l, Column: c, Err: fmt.Errorf("column %q is repeated", k)}
+		}
+		seen[k] = true
+	}
+	rows := []interface{}{}
+	for {
+		rec, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		m := NewMap()
+		for i, k := range head {
+			m.Set(k, field(rec[i], infer))
+		}
+		rows = append(rows, m)
+	}
+	return rows, nil
+}
+
+// field converts a table field to a value, numbers are json.Number.
+func field(s string, infer bool) interface{} {
+	switch {
+	case !infer:
+		return s
+	case s == "":
+		return nil
+	case strings.EqualFold(s, "true"):
+		return true
+	case strings.EqualFold(s, "false"):
+		return false
+	case numberRe.MatchString(s):
+		return json.Number(s)
+	}
+	return s
+}
+
+// asTable writes a table of records to w, fields are separated by comma. v
+// is a list of maps or a single map. Nested values are flattened to dotted
+// column names, list items are addressed by index. Columns are in the order
+// they are first seen, sorted unless every row is a *Map. A map without
+// leaves has no row, and a table without columns is not written.
+func asTable(w io.Writer, v interface{}, comma rune) error {
+	var vs []interface{}
+	switch v := v.(type) {
+	case nil:
+		return nil
+	case []interface{}:
+		vs = v
+	default:
+		vs = []interface{}{v}
+	}
+
+	rows := []map[string]string{}
+	cols := map[string]bool{}
+	head := []string{}
+	sorted := false
+	for i, v := range vs {
+		if !isMap(v) {
+			return fmt.Errorf("row %d: %T is not a map", i, v)
+		}
+		sorted = sorted || !isOrdered(v)
+		row := map[string]string{}
+		ks, err := flatten(row, "", v, nil)
+		if err != nil {
+			return fmt.Errorf("row %d: %s", i, err)
+		}
+		if len(row) > 0 {
+			rows = append(rows, row)
+		}
+		for _, k := range ks {
+			if !cols[k] {
+				cols[k] = true
+				head = append(head, k)
+			}
+		}
+	}
+	if len(head) == 0 {
+		return nil
+	}
+	if sorted {
+		sort.Strings(head)
+	}
+
+	cw := csv.NewWriter(w)
+	cw.Comma = comma
+	if err := cw.Write(head); err != nil {
+		return err
+	}
+	rec := make([]string, len(head))
+	for _, row := range rows {
+		for i, k := range head {
+			rec[i] = row[k]
+		}
+		// a record of one empty field would be a blank line, which is no
+		// record to a reader
+		if len(rec) == 1 && rec[0] == "" {
+			cw.Flush()
+			if _, err := io.WriteString(w, "\"\"\n"); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := cw.Write(rec); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// flatten writes the leaves of v to m keyed by dotted path, and returns ks
+// with the paths appended in order. Two leaves with the same path, keys "a.b"
+// and "a" with "b", are an error.
+func flatten(m map[string]string, path string, v interface{}, ks []string) ([]string, error) {
+	join := func(k string) string {
+		if path == "" {
+			return k
+		}
+		return path + "." + k
+	}
+	var err error
+	if mv, ok := toMap(v); ok {
+		for _, k := range mv.ks {
+			if ks, err = flatten(m, join(k), mv.m[k], ks); err != nil {
+				return nil, err
+			}
+		}
+		return ks, nil
+	}
+	switch v := v.(type) {
+	case []interface{}:
+		for i, u := range v {
+			if ks, err = flatten(m, join(strconv.Itoa(i)), u, ks); err != nil {
+				return nil, err
+			}
+		}
+	default:
+		if _, ok := m[path]; ok {
+			return nil, fmt.Errorf("more than one value for column %q", path)
+		}
+		m[path] = scalar(v)
+		ks = append(ks, path)
+	}
+	return ks, nil
+}
+
+// asCsv writes csv to w.
+func asCsv(w io.Writer, v interface{}) error {
+	return asTable(w, v, ',')
+}
+
+// asTsv writes tsv to w.
+func asTsv(w io.Writer, v interface{}) error {
+	return asTable(w, v, '\t')
+}
@@ -0,0 +1,125 @@
+package jam
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCsvDecode(t *testing.T) {
+	var ss = []struct {
+		i     string
+		infer bool
+		x     interface{}
+	}{
+		{"", true, nil},
+		{"a,b\n", true, _s{}},
+		{"a,b\n1,x\n", false, _s{_m{"a": "1", "b": "x"}}},
+		{"a,b\n1,x\n", true, _s{_m{"a": 1.0, "b": "x"}}},
+		{"a,b,c\n007,TRUE,\n", true, _s{_m{"a": "007", "b": true, "c": nil}}},
+		{"a,b\n-1.5e3,false\n2,\"x,y\"\n", true, _s{
+			_m{"a": -1500.0, "b": false},
+			_m{"a": 2.0, "b": "x,y"},
+		}},
+	}
+	for _, s := range ss {
+		var v interface{}
+		if err := NewDecoder(strings.NewReader(s.i)).AsCsv(s.infer).Decode(&v); err != nil {
+			t.Errorf("%q: %s", s.i, err)
+			continue
+		}
+		if !reflect.DeepEqual(v, s.x) {
+			t.Errorf("%q: expected %#v, got %#v", s.i, s.x, v)
+		}
+	}
+}
+
+func TestTsvDecode(t *testing.T) {
+	var v interface{}
+	err := NewDecoder(strings.NewReader("a\tb\n1\tx,y\n")).AsTsv(true).Decode(&v)
+	if err != nil {
+		t.Error(err)
+	}
+	x := _s{_m{"a": 1.0, "b": "x,y"}}
+	if !reflect.DeepEqual(v, x) {
+		t.Errorf("expected %#v, got %#v", x, v)
+	}
+}
+
+func TestCsvDecodeFail(t *testing.T) {
+	ss := []string{
+		"a,b\n1\n",
+		"a,b\n1,\"2\n",
+		"a,a\n1,2\n",
+	}
+	for _, s := range ss {
+		var v interface{}
+		if err := NewDecoder(strings.NewReader(s)).AsCsv(true).Decode(&v); err == nil {
+			t.Errorf("%q: expected error got none", s)
+		}
+	}
+}
+
+func TestCsvEncode(t *testing.T) {
+	var ss = []struct {
+		i interface{}
+		x string
+	}{
+		{_m{"a": 1, "b": "x"}, "a,b\n1,x\n"},
+		{_s{_m{"a": 1.5}, _m{"b": true}}, "a,b\n1.5,\n,true\n"},
+		{_s{_m{"a": _m{"b": 1, "c": _s{"x", "y"}}}}, "a.b,a.c.0,a.c.1\n1,x,y\n"},
+		{_s{_m{"a": "x,y", "b": nil}}, "a,b\n\"x,y\",\n"},
+		{_s{_m{"a.b": 1}, _m{"a": _m{"b": 2}}}, "a.b\n1\n2\n"},
+		{_s{_m{"a": 1}, _m{}, _m{"a": _m{}}, _m{"a": 2}}, "a\n1\n2\n"},
+		{_s{_m{}}, ""},
+		{_s{_m{"a": ""}, _m{"a": nil}}, "a\n\"\"\n\"\"\n"},
+	}
+	for _, s := range ss {
+		var bb bytes.Buffer
+		if err := NewEncoder(&bb).AsCsv().Encode(s.i); err != nil {
+			t.Error(err)
+		}
+		if bb.String() != s.x {
+			t.Errorf("%v: expected %q, got %q", s.i, s.x, bb.String())
+		}
+	}
+
+	for _, v := range []interface{}{_s{"x"}, _s{_m{"a.b": 1, "a": _m{"b": 2}}}} {
+		var bb bytes.Buffer
+		if err := NewEncoder(&bb).AsCsv().Encode(v); err == nil {
+			t.Errorf("%v: expected error got none", v)
+		}
+	}
+}
+
+func TestTableRoundTrip(t *testing.T) {
+	u := _s{
+		_m{"a": 1.0, "b": "x", "c": true},
+		_m{"a": 2.0, "b": "y z", "c": false},
+	}
+
+	var bb bytes.Buffer
+	if err := NewEncoder(&bb).AsCsv().Encode(u); err != nil {
+		t.Error(err)
+	}
+	var v interface{}
+	if err := NewDecoder(&bb).AsCsv(true).Decode(&v); err != nil {
+		t.Error(err)
+	}
+	if !reflect.DeepEqual(u, v) {
+		t.Errorf("csv: expected %#v, got %#v", u, v)
+	}
+
+	bb.Reset()
+	if err := NewEncoder(&bb).AsTsv().Encode(u); err != nil {
+		t.Error(err)
+	}
+	v = nil
+	if err := NewDecoder(&bb).AsTsv(true).Decode(&v); err != nil {
+		t.Error(err)
+	}
+	if !reflect.DeepEqual(u, v) {
+		t.Errorf("tsv: expected %#v, got %#v", u, v)
+	}
+}
@@ -0,0 +1,432 @@
+package jam
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+
+	yaml3 "gopkg.in/yaml.v3"
+)
+
+// Doc is a yaml document that keeps its comments, key order and scalar
+// styles. Decode into a *Doc to get one, input in any other format is a Doc
+// with a value and no document.
+//
+// A Jam that merges a Doc keeps the document, operations work on the value as
+// usual and Jam.Doc returns a Doc for the result. Parts of the result that are
+// unchanged keep their original layout, new parts are laid out like any
+// other yaml. The document starts with --- like any other yaml, too.
+type Doc struct {
+	n       *yaml3.Node
+	v       interface{}
+	tags    map[string]TagFunc
+	numbers bool
+	order   bool
+	indent  int
+
+	// o is the origin tree of v, nil when origins are not tracked
+	o interface{}
+}
+
+// Value returns the value of the document.
+func (d *Doc) Value() interface{} {
+	return d.v
+}
+
+// encode writes the document to w, or its value when there is no document.
+func (d *Doc) encode(w io.Writer) error {
+	if d.n == nil {
+		return asYaml(w, d.v)
+	}
+	if _, err := io.WriteString(w, "---\n"); err != nil {
+		return err
+	}
+	untag(d.n)
+	e := yaml3.NewEncoder(w)
+	e.SetIndent(d.indent)
+	if err := e.Encode(d.n); err != nil {
+		return err
+	}
+	return e.Close()
+}
+
+// untag clears the tags of merge keys in the tree of node n.
+func untag(n *yaml3.Node) {
+	for i, c := range n.Content {
+		if n.Kind == yaml3.MappingNode && i%2 == 0 && isMerge(c) {
+			c.Tag = ""
+		}
+		untag(c)
+	}
+}
+
+// with returns a copy of the document reconciled to value v.
+func (d *Doc) with(v interface{}) *Doc {
+	u := *d
+	u.v = v
+	if d.n != nil {
+		r := reconciler{
+			t:       newTagger(d.tags),
+			numbers: d.numbers,
+			order:   d.order,
+			vs:      map[*yaml3.Node]interface{}{},
+			anchors: map[string]*yaml3.Node{},
+		}
+		u.n = r.node(d.n, v)
+	}
+	return &u
+}
+
+// merge returns the union of documents d and e with preference to e.
+func (d *Doc) merge(e *Doc) *Doc {
+	if d == nil || d.n == nil {
+		return e
+	}
+	if e.n == nil {
+		return d
+	}
+	u := *d
+	u.n = relink(mergeNode(d.n, e.n), map[string]*yaml3.Node{})
+	if u.tags == nil {
+		u.tags = e.tags
+	}
+	return &u
+}
+
+// yamlIndent guesses the indent of yaml document b, the smallest indent of a
+// line in a block mapping, or 2 when there is none.
+func yamlIndent(b []byte) int {
+	indent := 0
+	for _, l := range bytes.Split(b, []byte("\n")) {
+		t := bytes.TrimLeft(l, " ")
+		n := len(l) - len(t)
+		if n == 0 || len(t) == 0 || t[0] == '#' || t[0] == '-' {
+			continue
+		}
+		if indent == 0 || n < indent {
+			indent = n
+		}
+	}
+	if indent < 2 || indent > 8 {
+		return 2
+	}
+	return indent
+}
+
+// mergeNode returns the union of nodes a and b with preference to b on
+// matching keys. Nodes from a are kept where possible, and so are their
+// comments.
+func mergeNode(a, b *yaml3.Node) *yaml3.Node {
+	switch {
+	case a.Kind == yaml3.DocumentNode && b.Kind == yaml3.DocumentNode:
+		c := *a
+		c.Content = []*yaml3.Node{mergeNode(a.Content[0], b.Content[0])}
+		return &c
+	case a.Kind == yaml3.MappingNode && b.Kind == yaml3.MappingNode:
+		c := *a
+		c.Content = append([]*yaml3.Node{}, a.Content...)
+		for i := 0; i+1 < len(b.Content); i += 2 {
+			k, v := b.Content[i], b.Content[i+1]
+			j := findKey(&c, k)
+			if j < 0 {
+				c.Content = append(c.Content, k, v)
+				continue
+			}
+			c.Content[j+1] = mergeNode(c.Content[j+1], v)
+		}
+		return &c
+	case a.Kind == yaml3.SequenceNode && b.Kind == yaml3.SequenceNode:
+		c := *a
+		c.Content = append([]*yaml3.Node{}, a.Content...)
+		for i, v := range b.Content {
+			if i < len(c.Content) {
+				c.Content[i] = mergeNode(c.Content[i], v)
+				continue
+			}
+			c.Content = append(c.Content, v)
+		}
+		return &c
+	}
+	c := *b
+	keepComments(&c, a)
+	return &c
+}
+
+// relink returns the tree of node n with aliases to the last node anchored
+// with their name, as the tree is read. Anchored nodes of merged trees are
+// copies, aliases are not.
+func relink(n *yaml3.Node, anchors map[string]*yaml3.Node) *yaml3.Node {
+	if n.Kind == yaml3.AliasNode {
+		if a, ok := anchors[n.Value]; ok && a != n.Alias {
+			c := *n
+			c.Alias = a
+			return &c
+		}
+		return n
+	}
+	c := n
+	for i, u := range n.Content {
+		if r := relink(u, anchors); r != u {
+			if c == n {
+				cc := *n
+				cc.Content = append([]*yaml3.Node{}, n.Content...)
+				c = &cc
+			}
+			c.Content[i] = r
+		}
+	}
+	if c.Anchor != "" {
+		anchors[c.Anchor] = c
+	}
+	return c
+}
+
+// findKey returns the index of the key in mapping node m that is written
+// like k, or -1.
+func findKey(m, k *yaml3.Node) int {
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		u := m.Content[i]
+		if u.Kind == k.Kind && u.Tag == k.Tag && u.Value == k.Value {
+			return i
+		}
+	}
+	return -1
+}
+
+// keepComments copies the comments of node a to node n where n has none.
+func keepComments(n, a *yaml3.Node) {
+	if n.HeadComment == "" {
+		n.HeadComment = a.HeadComment
+	}
+	if n.LineComment == "" {
+		n.LineComment = a.LineComment
+	}
+	if n.FootComment == "" {
+		n.FootComment = a.FootComment
+	}
+}
+
+// reconciler rebuilds a node tree for a value, reusing nodes where the values
+// match. Anchors are the anchored nodes of the result by name, an alias to
+// any other node is replaced by its value.
+type reconciler struct {
+	t       tagger
+	numbers bool
+	order   bool
+	vs      map[*yaml3.Node]interface{}
+	anchors map[string]*yaml3.Node
+}
+
+// value returns the value of node n as it is decoded, or an error value that
+// matches nothing when n can not be resolved.
+func (r reconciler) value(n *yaml3.Node) interface{} {
+	if v, ok := r.vs[n]; ok {
+		return v
+	}
+	var v interface{}
+	u, err := r.t.resolve(n)
+	if err == nil {
+		err = assign(u, &v, r.numbers)
+	}
+	if err != nil {
+		v = err
+	} else if !r.order {
+		v = plain(v)
+	}
+	r.vs[n] = v
+	return v
+}
+
+// stale reports whether the tree of node n has an alias to a node that is
+// not the anchored node of the result.
+func (r reconciler) stale(n *yaml3.Node) bool {
+	if n.Kind == yaml3.AliasNode {
+		a, ok := r.anchors[n.Value]
+		return ok && a != n.Alias
+	}
+	for _, c := range n.Content {
+		if r.stale(c) {
+			return true
+		}
+	}
+	return false
+}
+
+// node returns a node for v. Node n is returned as is when its value is v,
+// otherwise the result is built from n as far as the values agree.
+func (r reconciler) node(n *yaml3.Node, v interface{}) *yaml3.Node {
+	c := r.build(n, v)
+	if c == n {
+		r.mark(c)
+	} else if c.Anchor != "" {
+		r.anchors[c.Anchor] = c
+	}
+	return c
+}
+
+// mark records the anchored nodes in the tree of node n.
+func (r reconciler) mark(n *yaml3.Node) {
+	if n.Anchor != "" {
+		r.anchors[n.Anchor] = n
+	}
+	for _, c := range n.Content {
+		r.mark(c)
+	}
+}
+
+// build returns a node for v, see node.
+func (r reconciler) build(n *yaml3.Node, v interface{}) *yaml3.Node {
+	if !r.stale(n) && reflect.DeepEqual(r.value(n), v) {
+		return n
+	}
+	switch n.Kind {
+	case yaml3.DocumentNode:
+		c := *n
+		c.Content = []*yaml3.Node{r.node(n.Content[0], v)}
+		return &c
+	case yaml3.AliasNode:
+		c := *n.Alias
+		c.Anchor = ""
+		return r.node(&c, v)
+	}
+	if m, ok := toMap(v); ok && n.Kind == yaml3.MappingNode {
+		return r.mapping(n, m)
+	}
+	switch v := v.(type) {
+	case []interface{}:
+		if n.Kind == yaml3.SequenceNode {
+			return r.sequence(n, v)
+		}
+	}
+	c := yamlNode(v)
+	if s, ok := v.(string); ok && n.Kind == yaml3.ScalarNode && n.Style&^yaml3.TaggedStyle != 0 && c.Kind == yaml3.ScalarNode {
+		c.Value, c.Style = s, n.Style&^yaml3.TaggedStyle
+	}
+	keepComments(c, n)
+	return c
+}
+
+// mapping returns mapping node n rebuilt for map v. Keys keep their order,
+// new keys are added at the end in the order of v. Merge keys are kept when
+// everything they contribute is still in v.
+func (r reconciler) mapping(n *yaml3.Node, v *Map) *yaml3.Node {
+	own := map[string]bool{}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if k := n.Content[i]; !isMerge(k) {
+			if key, err := r.t.key(k); err == nil {
+				own[key] = true
+			}
+		}
+	}
+
+	c := *n
+	c.Content = nil
+	done := map[string]bool{}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		k, u := n.Content[i], n.Content[i+1]
+		if isMerge(k) {
+			m, ok := toMap(r.value(u))
+			if !ok || r.stale(u) {
+				continue
+			}
+			keep := true
+			for mk, mv := range m.m {
+				if w, ok := v.Get(mk); !own[mk] && (!ok || !reflect.DeepEqual(w, mv)) {
+					keep = false
+				}
+			}
+			if !keep {
+				continue
+			}
+			for mk := range m.m {
+				done[mk] = true
+			}
+			c.Content = append(c.Content, k, u)
+			continue
+		}
+		key, err := r.t.key(k)
+		if err != nil {
+			continue
+		}
+		w, ok := v.Get(key)
+		if !ok {
+			continue
+		}
+		c.Content = append(c.Content, k, r.node(u, w))
+		done[key] = true
+	}
+
+	for _, k := range v.ks {
+		if !done[k] {
+			c.Content = append(c.Content, yamlNode(k), yamlNode(v.m[k]))
+		}
+	}
+	return &c
+}
+
+// sequence returns sequence node n rebuilt for list v. Items are paired by
+// index when the lengths agree, otherwise each item is paired with the next
+// node that covers it, as after a filter.
+func (r reconciler) sequence(n *yaml3.Node, v []interface{}) *yaml3.Node {
+	c := *n
+	c.Content = make([]*yaml3.Node, len(v))
+	if len(v) == len(n.Content) {
+		for i, u := range v {
+			c.Content[i] = r.node(n.Content[i], u)
+		}
+		return &c
+	}
+	j := 0
+	for i, u := range v {
+		k := j
+		for k < len(n.Content) && !covers(r.value(n.Content[k]), u) {
+			k++
+		}
+		switch {
+		case k < len(n.Content):
+			c.Content[i] = r.node(n.Content[k], u)
+			j = k + 1
+		case j < len(n.Content):
+			c.Content[i] = r.node(n.Content[j], u)
+			j++
+		default:
+			c.Content[i] = yamlNode(u)
+		}
+	}
+	return &c
+}
+
+// covers reports whether b is a, or a with parts removed.
+func covers(a, b interface{}) bool {
+	if mb, ok := toMap(b); ok {
+		ma, ok := toMap(a)
+		if !ok {
+			return false
+		}
+		for k, v := range mb.m {
+			if u, ok := ma.m[k]; !ok || !covers(u, v) {
+				return false
+			}
+		}
+		return true
+	}
+	switch b := b.(type) {
+	case []interface{}:
+		a, ok := a.([]interface{})
+		if !ok {
+			return false
+		}
+		j := 0
+		for _, v := range b {
+			for j < len(a) && !covers(a[j], v) {
+				j++
+			}
+			if j == len(a) {
+				return false
+			}
+			j++
+		}
+		return true
+	}
+	return reflect.DeepEqual(a, b)
+}
@@ -0,0 +1,141 @@
+package jam
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+const docYaml = `# service config
+name: blep # the name
+version: "1.2.0"
+base: &base
+  port: 8080
+  host: localhost
+prod:
+  <<: *base
+  host: example.com
+list:
+  - one # first
+  - two
+  - three
+`
+
+func TestDoc(t *testing.T) {
+	var ss = []struct {
+		name string
+		fn   func(j *Jam)
+		x    string
+	}{
+		{"unchanged", func(j *Jam) {}, docYaml},
+		{
+			"merge",
+			func(j *Jam) { j.Merge(map[string]interface{}{"version": "1.3.0", "new": 1.0}) },
+			strings.Replace(docYaml, `"1.2.0"`, `"1.3.0"`, 1) + "new: 1\n",
+		},
+		{
+			"merge comment",
+			func(j *Jam) { j.Merge(map[string]interface{}{"name": "mlem"}) },
+			strings.Replace(docYaml, "name: blep", "name: mlem", 1),
+		},
+		{
+			"merge anchor",
+			func(j *Jam) { j.Merge(map[string]interface{}{"base": map[string]interface{}{"port": 9.0}}) },
+			strings.Replace(
+				strings.Replace(docYaml, "port: 8080", "port: 9", 1),
+				"  <<: *base\n  host: example.com\n", "  host: example.com\n  port: 8080\n", 1,
+			),
+		},
+		{
+			"filter",
+			func(j *Jam) { j.Filter("list[1:]") },
+			"list:\n  - two\n  - three\n",
+		},
+		{
+			"filter inverted",
+			func(j *Jam) { j.FilterI("list[1]") },
+			strings.Replace(docYaml, "  - two\n", "", 1),
+		},
+		{
+			"filter anchor",
+			func(j *Jam) { j.FilterI("base.port") },
+			strings.Replace(
+				strings.Replace(docYaml, "  port: 8080\n", "", 1),
+				"  <<: *base\n  host: example.com\n", "  host: example.com\n  port: 8080\n", 1,
+			),
+		},
+	}
+	for _, s := range ss {
+		var d Doc
+		if err := NewDecoder(strings.NewReader(docYaml)).Decode(&d); err != nil {
+			t.Fatal(err)
+		}
+		j := NewJam(&d)
+		s.fn(j)
+		var bb bytes.Buffer
+		if err := NewEncoder(&bb).Encode(j.Doc(0)); err != nil {
+			t.Errorf("%s: %s", s.name, err)
+			continue
+		}
+		if x := "---\n" + s.x; bb.String() != x {
+			t.Errorf("%s: expected\n%s\ngot\n%s", s.name, x, bb.String())
+		}
+	}
+}
+
+func TestDocMerge(t *testing.T) {
+	var ss = []struct {
+		a, b, x string
+	}{
+		{
+			"# a\na: 1 # one\nb: [1, 2]\n",
+			"b: [3]\n# c\nc: 3\n",
+			"# a\na: 1 # one\nb: [3, 2]\n# c\nc: 3\n",
+		},
+		{
+			docYaml,
+			"base: {port: 9}\n",
+			strings.Replace(
+				strings.Replace(docYaml, "port: 8080", "port: 9", 1),
+				"  <<: *base\n  host: example.com\n", "  host: example.com\n  port: 8080\n", 1,
+			),
+		},
+	}
+	for _, s := range ss {
+		var d Doc
+		if err := NewDecoder(strings.NewReader(s.a), strings.NewReader(s.b)).Decode(&d); err != nil {
+			t.Fatal(err)
+		}
+		var bb bytes.Buffer
+		if err := NewEncoder(&bb).Encode(&d); err != nil {
+			t.Fatal(err)
+		}
+		if x := "---\n" + s.x; bb.String() != x {
+			t.Errorf("expected\n%s\ngot\n%s", x, bb.String())
+		}
+	}
+}
+
+func TestDocEncode(t *testing.T) {
+	var d Doc
+	if err := NewDecoder(strings.NewReader("# a\na: 1 # one\nb: [3, 2]\n")).Decode(&d); err != nil {
+		t.Fatal(err)
+	}
+	var bb bytes.Buffer
+	if err := NewEncoder(&bb).AsJson().Encode(&d); err != nil {
+		t.Fatal(err)
+	}
+	if x := `{"a":1,"b":[3,2]}` + "\n"; bb.String() != x {
+		t.Errorf("expected %s, got %s", x, bb.String())
+	}
+}
+
+func TestDocNotYaml(t *testing.T) {
+	var d Doc
+	if err := NewDecoder(strings.NewReader(`{"a":1}`)).Decode(&d); err != nil {
+		t.Fatal(err)
+	}
+	if j := NewJam(&d); j.Doc(0) != nil {
+		t.Errorf("expected no doc for json")
+	}
+}
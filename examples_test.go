@@ -8,7 +8,7 @@ import (
 	"github.com/tr-d/jam"
 )
 
-func ExampleEncodeStructAsGo() {
+func ExampleEncoder_AsGo() {
 	x := struct {
 		A, B int
 	}{1, 2}
@@ -23,7 +23,7 @@ func ExampleEncodeStructAsGo() {
 	// }
 }
 
-func ExampleEncodeStructAsJson() {
+func ExampleEncoder_AsJson() {
 	x := struct {
 		A, B int
 	}{1, 2}
@@ -31,7 +31,7 @@ func ExampleEncodeStructAsJson() {
 	// Output: {"A":1,"B":2}
 }
 
-func ExampleEncodeStructAsStruct() {
+func ExampleEncoder_AsStruct() {
 	x := struct {
 		A, B int
 	}{1, 2}
@@ -43,7 +43,7 @@ func ExampleEncodeStructAsStruct() {
 	// }
 }
 
-func ExampleEncodeStructAsToml() {
+func ExampleEncoder_AsToml() {
 	x := struct {
 		A, B int
 	}{1, 2}
@@ -53,7 +53,7 @@ func ExampleEncodeStructAsToml() {
 	// B = 2
 }
 
-func ExampleEncodeStructAsYaml() {
+func ExampleEncoder_AsYaml() {
 	x := struct {
 		A, B int
 	}{1, 2}
@@ -64,7 +64,7 @@ func ExampleEncodeStructAsYaml() {
 	// B: 2
 }
 
-func ExampleStructTags() {
+func ExampleDecoder_Decode_structTags() {
 	s := `{"a":{"a":{"a":"blep"},"b":{"a":"mlem"}}}`
 	v := struct {
 		A string `jam:"a.a.a"`
@@ -75,7 +75,7 @@ func ExampleStructTags() {
 	// Output: {blep mlem}
 }
 
-func ExampleStructTagsPlus() {
+func ExampleDecoder_Decode_structTagsPlus() {
 	s := `[{"a":"blep","b":1},{"a":"mlem","b":-1}]`
 	v := struct {
 		As []string `jam:"[].a" json:"as"`
@@ -86,7 +86,7 @@ func ExampleStructTagsPlus() {
 	// Output: {"as":["blep","mlem"],"bs":[1,-1]}
 }
 
-func ExampleDecoderMerge() {
+func ExampleNewDecoder_merge() {
 	a := strings.NewReader(`a: blep`)
 	b := strings.NewReader(`{"b":"blep"}`)
 	c := strings.NewReader(`b = "mlem"`)
@@ -99,7 +99,7 @@ func ExampleDecoderMerge() {
 	// Output: {A:blep B:mlem}
 }
 
-func ExampleFileDecoderMerge() {
+func ExampleNewFileDecoder_merge() {
 	ss := []string{
 		"testdata/standard.yml",
 		"testdata/moar.json",
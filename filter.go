@@ -0,0 +1,643 @@
+package jam
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// FilterExpr is a compiled filter path, which filters any number of values.
+// See CompileFilter.
+type FilterExpr struct {
+	path string
+	bs   []branch
+}
+
+// FilterError is a filter path that does not compile. Column is where the
+// error was found in Path, counting from 1.
+type FilterError struct {
+	Path   string
+	Column int
+	Msg    string
+}
+
+func (e *FilterError) Error() string {
+	return fmt.Sprintf("filter %q: %d: %s", e.Path, e.Column, e.Msg)
+}
+
+// branch is the paths of a filter that must all match, the paths of an "&".
+// A branch of a filter that is a union, "|", matches what any branch does.
+type branch [][]step
+
+// step is a step of a filter path: map keys, list items, or a predicate on
+// the value, which is the last step.
+type step struct {
+	key   func(string) bool
+	items func([]interface{}) func(int) bool
+	pred  func(interface{}) bool
+}
+
+var (
+	sliceRe = regexp.MustCompile(`^(-?\d*)(?:(:)(-?\d*)(?:(:)(-?\d*))?)?$`)
+	typeRe  = regexp.MustCompile(`^:(string|number|bool|null|map|list)$`)
+	opRe    = regexp.MustCompile(`^(==|!=|<=|>=|=~|<|>)`)
+)
+
+// CompileFilter parses a filter path once, for Filter, FilterI, FilterR and
+// FilterIR of many values. A path that is not valid is a *FilterError.
+//
+// Paths separated by "|" are a union, which matches what any of them does,
+// and paths separated by "&" an intersection, which matches what all of
+// them do. "&" binds tighter than "|", and both may be in brackets too,
+// "[name==web|name==db]". A predicate value with "|" or "&" in it is quoted,
+// or in parentheses for a regular expression, "=~^(a|b)$".
+//
+// A path is map keys and list items separated by a dot, and may end in a
+// predicate on the values it matches.
+//
+// A key runs to the next ".", "[", "=", "<", ">" or "!=", and a backslash
+// escapes the character after it. A key with "*" or "?" in it is a glob, "*"
+// matches any characters and "?" one, a lone "*" matches any key. A key in
+// double or single quotes runs to the closing quote and is matched as it is,
+// a backslash escapes the character after it too. A key in slashes is a
+// regular expression, "\/" is a slash in it.
+//
+// List items are an index or a slice in brackets, like python, "[1]",
+// "[-1]", "[1:]", "[::2]", "[]" is every item. Indices out of range match
+// nothing, and slice bounds out of range are clamped. A filter path that
+// ends in a predicate in brackets selects the items it finds something in,
+// "[name==web]", "[tags[]==prod]". Brackets with anything else are an error.
+//
+// A predicate is an operator and a value. The operators are "==", "!=",
+// "<", "<=", ">" and ">=", the value of which is decoded from yaml, json or
+// toml, "=~", the value of which is a regular expression strings match, and
+// ":", the value of which is a type: string, number, bool, null, map or
+// list. Numbers compare by value and strings in byte order, other values are
+// neither less nor greater.
+func CompileFilter(path string) (*FilterExpr, error) {
+	bs, err := compiler{path}.expr(path, 0)
+	if err != nil {
+		return nil, err
+	}
+	return &FilterExpr{path: path, bs: bs}, nil
+}
+
+// String returns the filter path.
+func (e *FilterExpr) String() string {
+	return e.path
+}
+
+// Filter removes the elements of v that do not match, see Filter.
+func (e *FilterExpr) Filter(v interface{}) interface{} {
+	return filterer{}.apply(v, e.bs)
+}
+
+// FilterI removes the elements of v that match, see FilterI.
+func (e *FilterExpr) FilterI(v interface{}) interface{} {
+	return filterer{i: true}.apply(v, e.bs)
+}
+
+// FilterR removes the elements of v that do not match at any depth, see
+// FilterR.
+func (e *FilterExpr) FilterR(v interface{}) interface{} {
+	return filterer{r: true}.apply(v, e.bs)
+}
+
+// FilterIR removes the elements of v that match at any depth, see FilterIR.
+func (e *FilterExpr) FilterIR(v interface{}) interface{} {
+	return filterer{i: true, r: true}.apply(v, e.bs)
+}
+
+// compiler parses filter path path.
+type compiler struct {
+	path string
+}
+
+// fail returns a FilterError at byte offset i of the path.
+func (c compiler) fail(i int, format string, v ...interface{}) error {
+	return &FilterError{Path: c.path, Column: i + 1, Msg: fmt.Sprintf(format, v...)}
+}
+
+// expr parses s, the part of the path at byte offset off, into the branches
+// of a union.
+func (c compiler) expr(s string, off int) ([]branch, error) {
+	bs := []branch{}
+	for _, u := range split(s, off, '|') {
+		b := branch{}
+		for _, p := range split(u.s, u.off, '&') {
+			if p.s == "" && s != "" {
+				return nil, c.fail(p.off, "a path is missing")
+			}
+			ss, err := c.parse(p.s, p.off)
+			if err != nil {
+				return nil, err
+			}
+			b = append(b, ss)
+		}
+		bs = append(bs, b)
+	}
+	return bs, nil
+}
+
+// part is a part of a path at byte offset off.
+type part struct {
+	s   string
+	off int
+}
+
+// split splits s, the part of a path at byte offset off, at each sep that
+// is not escaped, quoted, in brackets or parentheses, or in a regular
+// expression key.
+func split(s string, off int, sep byte) []part {
+	ps := []part{}
+	depth, q, start := 0, byte(0), 0
+	for i := 0; i < len(s); i++ {
+		switch ch := s[i]; {
+		case ch == '\\':
+			i++
+		case q != 0:
+			if ch == q {
+				q = 0
+			}
+		case ch == '"' || ch == '\'':
+			q = ch
+		case ch == '/' && (i == 0 || strings.IndexByte(".[|&", s[i-1]) >= 0):
+			q = ch
+		case ch == '[' || ch == '(':
+			depth++
+		case (ch == ']' || ch == ')') && depth > 0:
+			depth--
+		case ch == sep && depth == 0:
+			ps = append(ps, part{s[start:i], off + start})
+			start = i + 1
+		}
+	}
+	return append(ps, part{s[start:], off + start})
+}
+
+// parse parses s, the part of the path at byte offset off, into steps.
+func (c compiler) parse(s string, off int) ([]step, error) {
+	ss := []step{}
+	for i := 0; i < len(s); {
+		if isPredicate(s[i:]) {
+			p, err := c.predicate(s[i:], off+i)
+			if err != nil {
+				return nil, err
+			}
+			return append(ss, step{pred: p}), nil
+		}
+		var (
+			st  step
+			n   int
+			err error
+		)
+		if s[i] == '[' {
+			st, n, err = c.items(s[i:], off+i)
+		} else {
+			st, n, err = c.key(s[i:], off+i)
+		}
+		if err != nil {
+			return nil, err
+		}
+		ss, i = append(ss, st), i+n
+		switch {
+		case i == len(s) || s[i] == '[' || isPredicate(s[i:]):
+		case s[i] == '.' && (i+1 == len(s) || s[i+1] == '.' || isPredicate(s[i+1:])):
+			return nil, c.fail(off+i+1, "a key is missing after the dot")
+		case s[i] == '.':
+			i++
+		default:
+			return nil, c.fail(off+i, "unexpected %q", s[i])
+		}
+	}
+	return ss, nil
+}
+
+// isPredicate reports whether s is a predicate, or starts with an operator.
+func isPredicate(s string) bool {
+	return opRe.MatchString(s) || typeRe.MatchString(s)
+}
+
+// key parses the key at the start of s, at byte offset off, and returns the
+// number of bytes it takes.
+func (c compiler) key(s string, off int) (step, int, error) {
+	if s[0] == '/' {
+		var b strings.Builder
+		for i := 1; i < len(s); i++ {
+			switch ch := s[i]; {
+			case ch == '\\' && i+1 < len(s) && s[i+1] == '/':
+				i++
+				b.WriteByte('/')
+			case ch == '\\' && i+1 < len(s):
+				i++
+				b.WriteString(s[i-1 : i+1])
+			case ch == '/':
+				re, err := regexp.Compile(b.String())
+				if err != nil {
+					return step{}, 0, c.fail(off, "bad regular expression: %s", err)
+				}
+				return step{key: re.MatchString}, i + 1, nil
+			default:
+				b.WriteByte(ch)
+			}
+		}
+		return step{}, 0, c.fail(off, "the regular expression is not closed")
+	}
+	var (
+		b, g strings.Builder
+		i    int
+		q    byte
+		glob bool
+	)
+	if s[0] == '"' || s[0] == '\'' {
+		q, i = s[0], 1
+	}
+	key := func(n int) (step, int, error) {
+		if !glob {
+			k := b.String()
+			return step{key: func(s string) bool { return s == k }}, n, nil
+		}
+		re, err := regexp.Compile("^" + g.String() + "$")
+		if err != nil {
+			return step{}, 0, c.fail(off, "bad glob: %s", err)
+		}
+		return step{key: re.MatchString}, n, nil
+	}
+	for ; i < len(s); i++ {
+		ch := s[i]
+		switch {
+		case ch == '\\' && i+1 < len(s):
+			i++
+			ch = s[i]
+		case ch == '\\':
+			return step{}, 0, c.fail(off+i, "nothing to escape")
+		case q != 0 && ch == q:
+			return key(i + 1)
+		case q == 0 && (ch == '.' || ch == '[' || ch == '=' || ch == '<' || ch == '>' ||
+			ch == '!' && strings.HasPrefix(s[i:], "!=") || ch == ':' && typeRe.MatchString(s[i:])):
+			if i == 0 {
+				return step{}, 0, c.fail(off, "a key is missing")
+			}
+			return key(i)
+		case q == 0 && ch == '*':
+			glob = true
+			g.WriteString(".*")
+			continue
+		case q == 0 && ch == '?':
+			glob = true
+			g.WriteString(".")
+			continue
+		}
+		b.WriteByte(ch)
+		g.WriteString(regexp.QuoteMeta(s[i : i+1]))
+	}
+	if q != 0 {
+		return step{}, 0, c.fail(off, "the quote is not closed")
+	}
+	return key(i)
+}
+
+// items parses the index, slice or filter path in brackets at the start of
+// s, at byte offset off, and returns the number of bytes it takes.
+func (c compiler) items(s string, off int) (step, int, error) {
+	n, depth, q := 0, 0, byte(0)
+	for i := 1; i < len(s) && n == 0; i++ {
+		switch ch := s[i]; {
+		case ch == '\\':
+			i++
+		case q != 0:
+			if ch == q {
+				q = 0
+			}
+		case ch == '"' || ch == '\'':
+			q = ch
+		case ch == '[':
+			depth++
+		case ch == ']' && depth > 0:
+			depth--
+		case ch == ']':
+			n = i
+		}
+	}
+	if n == 0 {
+		return step{}, 0, c.fail(off, "the bracket is not closed")
+	}
+	inner := s[1:n]
+	ms := sliceRe.FindStringSubmatch(inner)
+	if ms == nil {
+		bs, err := c.expr(inner, off+1)
+		if err != nil {
+			return step{}, 0, err
+		}
+		// each path ends in a predicate, "[x]" is neither an index nor a
+		// filter
+		for i, u := range split(inner, off+1, '|') {
+			for j, p := range split(u.s, u.off, '&') {
+				if ss := bs[i][j]; len(ss) == 0 || ss[len(ss)-1].pred == nil {
+					return step{}, 0, c.fail(p.off, "%q is not an index, a slice or a path with a predicate", p.s)
+				}
+			}
+		}
+		return step{items: func(l []interface{}) func(int) bool {
+			return func(i int) bool {
+				_, ok := filterer{}.filter(l[i], bs)
+				return ok
+			}
+		}}, n + 1, nil
+	}
+	var ns [3]*int
+	for k, m := range []string{ms[1], ms[3], ms[5]} {
+		if m == "" {
+			continue
+		}
+		u, err := strconv.Atoi(m)
+		if err != nil {
+			return step{}, 0, c.fail(off+1, "bad index %q", m)
+		}
+		ns[k] = &u
+	}
+	switch {
+	case ms[2] == "" && ns[0] == nil:
+		return step{items: func(l []interface{}) func(int) bool {
+			return func(int) bool { return true }
+		}}, n + 1, nil
+	case ms[2] == "":
+		return step{items: func(l []interface{}) func(int) bool {
+			k := *ns[0]
+			if k < 0 {
+				k += len(l)
+			}
+			return func(i int) bool { return i == k }
+		}}, n + 1, nil
+	case ns[2] != nil && *ns[2] == 0:
+		return step{}, 0, c.fail(off+1, "the step of a slice is 0")
+	}
+	return step{items: func(l []interface{}) func(int) bool {
+		return slice(len(l), ns[0], ns[1], ns[2])
+	}}, n + 1, nil
+}
+
+// slice returns whether each index of a list of length length is in the
+// slice from lb to ub by step, like python. Bounds may be nil.
+func slice(length int, lb, ub, step *int) func(int) bool {
+	st := 1
+	if step != nil {
+		st = *step
+	}
+	lo, hi := 0, length
+	if st < 0 {
+		lo, hi = length-1, -1
+	}
+	bound := func(b *int, n *int) {
+		if b == nil {
+			return
+		}
+		*n = *b
+		if *n < 0 {
+			*n += length
+		}
+		switch {
+		case *n < 0 && st < 0:
+			*n = -1
+		case *n < 0:
+			*n = 0
+		case *n >= length && st < 0:
+			*n = length - 1
+		case *n >= length:
+			*n = length
+		}
+	}
+	bound(lb, &lo)
+	bound(ub, &hi)
+	if st > 0 {
+		return func(i int) bool { return i >= lo && i < hi && (i-lo)%st == 0 }
+	}
+	return func(i int) bool { return i <= lo && i > hi && (lo-i)%-st == 0 }
+}
+
+// predicate parses predicate s, at byte offset off.
+func (c compiler) predicate(s string, off int) (func(interface{}) bool, error) {
+	if ms := typeRe.FindStringSubmatch(s); ms != nil {
+		t := ms[1]
+		return func(v interface{}) bool { return typeOf(v) == t }, nil
+	}
+	op := opRe.FindString(s)
+	s, off = s[len(op):], off+len(op)
+	if s == "" {
+		return nil, c.fail(off, "a value is missing after %s", op)
+	}
+	if op == "=~" {
+		re, err := regexp.Compile(s)
+		if err != nil {
+			return nil, c.fail(off, "bad regular expression: %s", err)
+		}
+		return func(v interface{}) bool {
+			s, ok := v.(string)
+			return ok && re.MatchString(s)
+		}, nil
+	}
+	var u interface{}
+	if err := NewDecoder(strings.NewReader(s)).UseNumber().Decode(&u); err != nil {
+		return nil, c.fail(off, "bad value: %s", err)
+	}
+	switch op {
+	case "==":
+		return func(v interface{}) bool { return equal(v, u) }, nil
+	case "!=":
+		return func(v interface{}) bool { return !equal(v, u) }, nil
+	}
+	return func(v interface{}) bool {
+		c, ok := compare(v, u)
+		switch op {
+		case "<":
+			return ok && c < 0
+		case "<=":
+			return ok && c <= 0
+		case ">":
+			return ok && c > 0
+		}
+		return ok && c >= 0
+	}, nil
+}
+
+// compare returns -1, 0 or 1 as a is less than, equal to or greater than b,
+// when they are both numbers or both strings.
+func compare(a, b interface{}) (int, bool) {
+	if x, ok := number(a); ok {
+		y, ok := number(b)
+		if !ok {
+			return 0, false
+		}
+		return x.Cmp(y), true
+	}
+	x, ok := a.(string)
+	y, yok := b.(string)
+	if !ok || !yok {
+		return 0, false
+	}
+	return strings.Compare(x, y), true
+}
+
+// typeOf returns the name of the type of v for a type predicate.
+func typeOf(v interface{}) string {
+	if isMap(v) {
+		return "map"
+	}
+	switch v.(type) {
+	case nil:
+		return "null"
+	case string:
+		return "string"
+	case bool:
+		return "bool"
+	case []interface{}:
+		return "list"
+	}
+	if _, ok := number(v); ok {
+		return "number"
+	}
+	return ""
+}
+
+// filterer filters by the branches of a filter, inverted when i is set and
+// at any depth when r is set, bs are the branches of the whole filter.
+type filterer struct {
+	i, r bool
+	bs   []branch
+}
+
+// apply filters v by branches bs, nil is nothing left.
+func (f filterer) apply(v interface{}, bs []branch) interface{} {
+	f.bs = bs
+	v, ok := f.filter(v, bs)
+	if !ok {
+		return nil
+	}
+	return v
+}
+
+// filter filters v by the branches bs that are left at v.
+func (f filterer) filter(v interface{}, bs []branch) (interface{}, bool) {
+	// the paths that end in a predicate v passes are done, the branches
+	// with a predicate v fails are dropped
+	live := []branch{}
+	for _, b := range bs {
+		d, done := descend(b, func(p []step) (bool, bool) {
+			return p[0].pred != nil, p[0].pred == nil || p[0].pred(v)
+		})
+		switch {
+		case d == nil:
+		case done && f.i:
+			return nil, false
+		case done:
+			return v, true
+		default:
+			live = append(live, d)
+		}
+	}
+	if m, ok := toMap(v); ok {
+		o := NewMap()
+		for _, k := range m.ks {
+			v := m.m[k]
+			subs := []branch{}
+			for _, b := range live {
+				if d, _ := descend(b, func(p []step) (bool, bool) { return true, p[0].key != nil && p[0].key(k) }); d != nil {
+					subs = append(subs, d)
+				}
+			}
+			switch {
+			case len(subs) > 0:
+				if tmp, ok := f.filter(v, subs); ok {
+					o.Set(k, tmp)
+				}
+			case f.r:
+				if tmp, ok := f.filter(v, f.bs); ok {
+					o.Set(k, tmp)
+				}
+			case f.i:
+				o.Set(k, v)
+			}
+		}
+		if isOrdered(v) {
+			return o, f.i || o.Len() > 0
+		}
+		return o.m, f.i || o.Len() > 0
+	}
+	switch v := v.(type) {
+	case []interface{}:
+		// ok is whether a branch is list items all along, the items of each
+		// step are worked out once for the list
+		ok := false
+		ins := map[*step]func(int) bool{}
+		for _, b := range live {
+			all := true
+			for _, p := range b {
+				switch {
+				case len(p) == 0:
+				case p[0].items == nil:
+					all = false
+				default:
+					ins[&p[0]] = p[0].items(v)
+				}
+			}
+			ok = ok || all
+		}
+		o := []interface{}{}
+		for i := 0; i < len(v); i++ {
+			subs := []branch{}
+			for _, b := range live {
+				if d, _ := descend(b, func(p []step) (bool, bool) { return true, p[0].items != nil && ins[&p[0]](i) }); d != nil {
+					subs = append(subs, d)
+				}
+			}
+			switch {
+			case f.r && len(subs) > 0:
+				a, aok := f.filter(v[i], subs)
+				b, bok := f.filter(a, f.bs)
+				switch {
+				case aok && bok:
+					o = append(o, b)
+				case aok:
+					o = append(o, a)
+				}
+			case len(subs) > 0:
+				if tmp, ok := f.filter(v[i], subs); ok {
+					o = append(o, tmp)
+				}
+			case f.r && !ok:
+				if tmp, ok := f.filter(v[i], f.bs); ok {
+					o = append(o, tmp)
+				}
+			case f.i:
+				o = append(o, v[i])
+			}
+		}
+		return o, f.i || len(o) > 0
+	}
+	if f.i {
+		return v, true
+	}
+	return nil, false
+}
+
+// descend returns branch b with the first step of each of its paths taken
+// when it matches, or nil when it does not. Steps that match reports are not
+// to be taken are kept, and paths that are done match anything. It also
+// returns whether every path is done.
+func descend(b branch, match func([]step) (take, ok bool)) (branch, bool) {
+	d, done := make(branch, len(b)), true
+	for i, p := range b {
+		if len(p) > 0 {
+			take, ok := match(p)
+			if !ok {
+				return nil, false
+			}
+			if take {
+				p = p[1:]
+			}
+		}
+		d[i], done = p, done && len(p) == 0
+	}
+	return d, done
+}
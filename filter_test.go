@@ -0,0 +1,187 @@
+package jam
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestCompileFilter(t *testing.T) {
+	var ss = []struct {
+		path   string
+		column int
+	}{
+		{"", 0},
+		{"a.b[0].c==1", 0},
+		{`"a.b".'c'[name==web][-1:].d>=2`, 0},
+		{"/^x-/.*Port:number", 0},
+		{"[]", 0},
+		{"a.[0]", 0},
+		{"a..b", 3},
+		{".a", 1},
+		{"a.", 3},
+		{"a.==1", 3},
+		{"a=b", 2},
+		{"a[0]b", 5},
+		{`"a`, 1},
+		{`a\`, 2},
+		{"/a", 1},
+		{"/(/", 1},
+		{"a*\xff", 1},
+		{"b.a?\xff", 3},
+		{"a[0", 2},
+		{"a[1:2:0]", 3},
+		{"a[99999999999999999999]", 3},
+		{"a[b..c]", 5},
+		{"a[b=]", 4},
+		{"[::0]", 2},
+		{"[]=~(", 5},
+		{"a==", 4},
+		{"a=~(", 4},
+		{"a==[1", 4},
+		{"a|b&c.d", 0},
+		{"a|", 3},
+		{"|a", 1},
+		{"a&&b", 3},
+		{"a[b|]", 5},
+		{"a[x]", 3},
+		{"a[b==1|c]", 8},
+		{"a[b==1&c.d]", 8},
+		{"a[[0]]", 3},
+		{"a|b..c", 5},
+	}
+	for _, s := range ss {
+		_, err := CompileFilter(s.path)
+		var fe *FilterError
+		switch {
+		case s.column == 0 && err != nil:
+			t.Errorf("%q: expected no error, got %s", s.path, err)
+		case s.column == 0:
+		case !errors.As(err, &fe):
+			t.Errorf("%q: expected a FilterError, got %v", s.path, err)
+		case fe.Column != s.column || fe.Path != s.path:
+			t.Errorf("%q: expected column %d, got %s", s.path, s.column, err)
+		}
+	}
+}
+
+func TestFilterExpr(t *testing.T) {
+	e, err := CompileFilter("items[status.replicas>1].name")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.String() != "items[status.replicas>1].name" {
+		t.Errorf("expected the path, got %s", e)
+	}
+	var ss = []struct {
+		d, x, i interface{}
+	}{
+		{
+			_m{"items": _s{_m{"name": "a", "status": _m{"replicas": 2}}, _m{"name": "b", "status": _m{"replicas": 1}}}},
+			_m{"items": _s{_m{"name": "a"}}},
+			_m{"items": _s{_m{"status": _m{"replicas": 2}}, _m{"name": "b", "status": _m{"replicas": 1}}}},
+		},
+		{
+			_m{"items": _s{_m{"name": "c", "status": _m{"replicas": 3}}}},
+			_m{"items": _s{_m{"name": "c"}}},
+			_m{"items": _s{_m{"status": _m{"replicas": 3}}}},
+		},
+		{_m{"items": _s{}}, nil, _m{"items": _s{}}},
+	}
+	for _, s := range ss {
+		if v := e.Filter(s.d); !reflect.DeepEqual(v, s.x) {
+			t.Errorf("expected %v, got %v", s.x, v)
+		}
+		if v := e.FilterI(s.d); !reflect.DeepEqual(v, s.i) {
+			t.Errorf("inverted, expected %v, got %v", s.i, v)
+		}
+	}
+}
+
+func TestFilterUnion(t *testing.T) {
+	deploy := _m{
+		"metadata": _m{"name": "web", "namespace": "prod"},
+		"spec":     _m{"replicas": 3, "paused": false},
+	}
+	var ss = []struct {
+		path    string
+		d, x, i interface{}
+	}{
+		{
+			"metadata.name|spec.replicas", deploy,
+			_m{"metadata": _m{"name": "web"}, "spec": _m{"replicas": 3}},
+			_m{"metadata": _m{"namespace": "prod"}, "spec": _m{"paused": false}},
+		},
+		{"a|a.b", _m{"a": _m{"b": 1, "c": 2}, "d": 3}, _m{"a": _m{"b": 1, "c": 2}}, _m{"d": 3}},
+		{"[0]|[2]", _s{"a", "b", "c"}, _s{"a", "c"}, _s{"b"}},
+		{"[0]|[0]", _s{"a", "b", "c"}, _s{"a"}, _s{"b", "c"}},
+		{"*.name&a.*", _m{"a": _m{"name": 1, "x": 2}, "b": _m{"name": 3}}, _m{"a": _m{"name": 1}}, _m{"a": _m{"x": 2}, "b": _m{"name": 3}}},
+		{"[0:2]&[1:3]", _s{"a", "b", "c", "d"}, _s{"b"}, _s{"a", "c", "d"}},
+		{"a&b", _m{"a": 1, "b": 2}, nil, _m{"a": 1, "b": 2}},
+		{"a&a==1", _m{"a": 1, "b": 2}, _m{"a": 1}, _m{"b": 2}},
+		{"a&a==2", _m{"a": 1, "b": 2}, nil, _m{"a": 1, "b": 2}},
+		{"a|b&c", _m{"a": 1, "b": 2, "c": 3}, _m{"a": 1}, _m{"b": 2, "c": 3}},
+		{
+			"[name==web|name==db].image",
+			_s{_m{"name": "web", "image": "nginx"}, _m{"name": "db", "image": "pg"}, _m{"name": "x", "image": "y"}},
+			_s{_m{"image": "nginx"}, _m{"image": "pg"}},
+			_s{_m{"name": "web"}, _m{"name": "db"}, _m{"name": "x", "image": "y"}},
+		},
+		{"[]=~^(a|b)$", _s{"a", "b", "c"}, _s{"a", "b"}, _s{"c"}},
+		{`[]=="a|b"`, _s{"a|b", "a"}, _s{"a|b"}, _s{"a"}},
+		{"/a|b/", _m{"a": 1, "b": 2, "c": 3}, _m{"a": 1, "b": 2}, _m{"c": 3}},
+		{`a\|b`, _m{"a|b": 1, "a": 2}, _m{"a|b": 1}, _m{"a": 2}},
+	}
+	for _, s := range ss {
+		e, err := CompileFilter(s.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v := e.Filter(s.d); !reflect.DeepEqual(v, s.x) {
+			t.Errorf("%q: expected %v, got %v", s.path, s.x, v)
+		}
+		if v := e.FilterI(s.d); !reflect.DeepEqual(v, s.i) {
+			t.Errorf("%q: inverted, expected %v, got %v", s.path, s.i, v)
+		}
+	}
+}
+
+func TestFilterUnionR(t *testing.T) {
+	d := _m{"spec": _m{"containers": _s{_m{"name": "web", "image": "nginx", "ports": _s{80}}}}, "name": "pod"}
+	var ss = []struct {
+		path string
+		x, i interface{}
+	}{
+		{
+			"name|image",
+			_m{"spec": _m{"containers": _s{_m{"name": "web", "image": "nginx"}}}, "name": "pod"},
+			_m{"spec": _m{"containers": _s{_m{"ports": _s{80}}}}},
+		},
+		{
+			"name&name==web",
+			_m{"spec": _m{"containers": _s{_m{"name": "web"}}}},
+			_m{"spec": _m{"containers": _s{_m{"image": "nginx", "ports": _s{80}}}}, "name": "pod"},
+		},
+	}
+	for _, s := range ss {
+		if v := FilterR(d, s.path); !reflect.DeepEqual(v, s.x) {
+			t.Errorf("%q: expected %v, got %v", s.path, s.x, v)
+		}
+		if v := FilterIR(d, s.path); !reflect.DeepEqual(v, s.i) {
+			t.Errorf("%q: inverted, expected %v, got %v", s.path, s.i, v)
+		}
+	}
+}
+
+func TestJamFilterError(t *testing.T) {
+	j := NewJam(_m{"a": 1})
+	if err := j.Filter("a..b"); err == nil {
+		t.Error("expected an error")
+	}
+	if !reflect.DeepEqual(j.Value(0), _m{"a": 1}) {
+		t.Errorf("expected the value to be unchanged, got %v", j.Value(0))
+	}
+	if err := j.FilterR("a"); err != nil || !reflect.DeepEqual(j.Value(0), _m{"a": 1}) {
+		t.Errorf("expected %v, got %v %v", _m{"a": 1}, j.Value(0), err)
+	}
+}
@@ -1,12 +1,16 @@
-// Package jam. Decode yaml, json or toml. Encode yaml, json, toml, go syntax and
-// go struct defs.  Merge, Diff, Filter, Query things. Struct tags.
+// Package jam. Decode yaml, json, toml, xml, csv or tsv. Encode yaml, json,
+// toml, xml, csv, tsv, go syntax and go struct defs.  Merge, Diff, Filter, Query
+// things. Struct tags.
 //
 // If structured data is scones and you are clotted cream, this is jam.
 package jam
 
 import (
+	"bufio"
 	"bytes"
+	"encoding/csv"
 	"encoding/json"
+	"encoding/xml"
 	"errors"
 	"fmt"
 	"go/format"
@@ -14,101 +18,339 @@ import (
 	"io/ioutil"
 	"os"
 	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
 	"text/template"
+	"time"
 
 	"github.com/BurntSushi/toml"
-	"github.com/ghodss/yaml"
 	jmespath "github.com/jmespath/go-jmespath"
+	yaml3 "gopkg.in/yaml.v3"
 )
 
-// decoder reads yaml, json, or toml from a reader, "jam" struct tags are
+// decoder reads yaml, json, toml, or xml from a reader, "jam" struct tags are
 // evaluated as jmespath expressions.
 type decoder struct {
-	r    io.Reader
-	jd   *json.Decoder
-	once bool
+	r       io.Reader
+	br      *bufio.Reader
+	jd      *json.Decoder
+	once    bool
+	lang    lang
+	infer   bool
+	tags    map[string]TagFunc
+	numbers bool
+	order   bool
+
+	// doc is set to decode yaml documents into node, which is nil for other
+	// input.
+	doc  bool
+	node *yaml3.Node
+
+	// origins is set to track the origins of values, o is the origin tree
+	// of the last json value.
+	origins bool
+	o       interface{}
+
+	// name is the source name used in errors, src is the input being decoded,
+	// line is the number of lines before src and skip the number of lines to
+	// add to line before the next value.
+	name       string
+	src        []byte
+	line, skip int
 }
 
-// newDecoder creates a decoder for r.
-func newDecoder(r io.Reader) *decoder {
-	return &decoder{r: r}
-}
-
-// Decode json, yaml, or toml from the reader and store the result in the value
-// pointed to by v. Struct tags labeled "jam" are evaluated as jmespath
+// Decode json, yaml, toml, or xml from the reader and store the result in the
+// value pointed to by v. Struct tags labeled "jam" are evaluated as jmespath
 // expressions.
 func (d *decoder) Decode(v interface{}) error {
 	defer func() { d.once = true }()
-	var (
-		err error
-		u   interface{}
-	)
-	switch {
-	case d.jd != nil:
-		if !d.jd.More() {
-			return ErrNoMore{}
+	doc, ok := v.(*Doc)
+	d.doc = ok
+	u, l, err := d.next()
+	if IsNoMore(err) {
+		return err
+	}
+	if err != nil {
+		return d.fail(l, err)
+	}
+	if ok {
+		*doc = Doc{n: d.node, tags: d.tags, numbers: d.numbers, order: d.order, indent: yamlIndent(d.src)}
+		if d.origins {
+			doc.o = d.originsOf(u, l)
 		}
-		if err := d.jd.Decode(&u); err != nil {
-			return err
+		v = &doc.v
+	}
+	if !d.order {
+		u = plain(u)
+	}
+	if err := assign(u, v, d.numbers); err != nil {
+		return &DecodeError{Source: d.name, Format: l.String(), Cause: err}
+	}
+	return nil
+}
+
+// assign stores u in the value pointed to by v by way of json. Struct tags
+// labeled "jam" are evaluated as jmespath expressions. Numbers are json.Number
+// when numbers is true, float64 otherwise. A *Map is kept when v is an
+// *interface{}.
+func assign(u, v interface{}, numbers bool) error {
+	if p, ok := v.(*interface{}); ok {
+		var err error
+		*p, err = tree(u, numbers)
+		return err
+	}
+
+	u, err := remap(plain(u), reflect.TypeOf(v))
+	if err != nil {
+		return err
+	}
+
+	var bb bytes.Buffer
+	if err := NewEncoder(&bb).AsJson().Encode(u); err != nil {
+		return err
+	}
+
+	jd := json.NewDecoder(&bb)
+	if numbers {
+		jd.UseNumber()
+	}
+	return jd.Decode(v)
+}
+
+// next decodes the next value from the reader, it returns the value and the
+// language it was decoded from.
+func (d *decoder) next() (interface{}, lang, error) {
+	var u interface{}
+	d.line, d.skip = d.line+d.skip, 0
+	d.node, d.o = nil, nil
+	if d.lang == lJsonl {
+		return d.nextLine()
+	}
+	if d.jd != nil {
+		if !d.jd.More() {
+			return nil, lJson, ErrNoMore{}
 		}
-	default:
-		b, err := ioutil.ReadAll(d.r)
-		if err != nil {
-			return err
+		u, err := d.json(d.jd)
+		return u, lJson, err
+	}
+
+	b, err := ioutil.ReadAll(d.r)
+	if err != nil {
+		return nil, d.lang, err
+	}
+	if d.once && len(b) == 0 {
+		return nil, d.lang, ErrNoMore{}
+	}
+	d.src = b
+	a := analyze(b)
+	if d.lang != lUnknown {
+		a.lang = d.lang
+	}
+	switch a.lang {
+	case lCsv, lTsv:
+		comma := ','
+		if a.lang == lTsv {
+			comma = '\t'
 		}
-		if d.once && len(b) == 0 {
-			return ErrNoMore{}
+		u, err = decodeTable(b, comma, d.infer)
+	case lToml:
+		var md toml.MetaData
+		md, err = toml.Decode(string(b), &u)
+		u = orderToml(u, md)
+	case lXml:
+		u, err = decodeXml(b)
+	case lJson:
+		if len(bytes.TrimSpace(b)) == 0 {
+			return nil, a.lang, ErrNoMore{}
 		}
-		a := analyze(b)
-		switch a.lang {
-		case lToml:
-			_, err = toml.Decode(string(b), &u)
-			if err != nil {
-				return err
-			}
-		default:
-			if !d.once {
-				// this step protects json from yaml specific errs
-				jd := json.NewDecoder(bytes.NewReader(b))
-				if err = jd.Decode(&u); err == nil {
-					d.jd = jd
-					break
-				}
-			}
-			if len(a.errs) > 0 {
-				return a.nerrs(6)
+		d.jd = json.NewDecoder(bytes.NewReader(b))
+		d.jd.UseNumber()
+		u, err = d.json(d.jd)
+	default:
+		if !d.once {
+			// this step protects json from yaml specific errs
+			jd := json.NewDecoder(bytes.NewReader(b))
+			jd.UseNumber()
+			if u, err = d.json(jd); err == nil {
+				d.jd = jd
+				return u, lJson, nil
 			}
-			b = bytes.TrimPrefix(b, []byte("---\n"))
-			// \n---\n can only be a yaml document separator
-			// this is a safe split, apparently
-			bs := bytes.SplitN(b, []byte("\n---\n"), 2)
-			err = yaml.Unmarshal(bs[0], &u)
-			if len(bs) > 1 {
-				d.r = bytes.NewReader(bs[1])
+		}
+		a.lang = lYaml
+		for _, err := range a.errs {
+			// tags are resolved, or found wanting, by the tagger
+			if _, ok := err.(*tagErr); ok {
+				continue
 			}
-			if err != nil {
-				return err
+			return nil, a.lang, err
+		}
+		if bytes.HasPrefix(b, []byte("---\n")) {
+			b = b[4:]
+			d.line++
+		}
+		// \n---\n can only be a yaml document separator
+		// this is a safe split, apparently
+		bs := bytes.SplitN(b, []byte("\n---\n"), 2)
+		d.src = bs[0]
+		u, d.node, err = decodeYaml(bs[0], d.tags)
+		if len(bs) > 1 {
+			d.r = bytes.NewReader(bs[1])
+			d.skip = bytes.Count(bs[0], []byte("\n")) + 2
+		}
+	}
+	return u, a.lang, err
+}
+
+// nextLine decodes the next line of json lines, blank lines are skipped.
+func (d *decoder) nextLine() (interface{}, lang, error) {
+	if d.br == nil {
+		d.br = bufio.NewReader(d.r)
+	}
+	for {
+		b, err := d.br.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return nil, lJsonl, err
+		}
+		if len(bytes.TrimSpace(b)) == 0 {
+			if err == io.EOF {
+				return nil, lJsonl, ErrNoMore{}
 			}
+			d.line++
+			continue
 		}
+		d.src, d.skip = b, 1
+		jd := json.NewDecoder(bytes.NewReader(b))
+		jd.UseNumber()
+		u, err := d.json(jd)
+		if err != nil {
+			return nil, lJsonl, err
+		}
+		n := jd.InputOffset()
+		if i := len(b[n:]) - len(bytes.TrimLeft(b[n:], " \t\r\n")); n+int64(i) < int64(len(b)) {
+			l, c := offset(b, n+int64(i)+1)
+			return nil, lJsonl, &moreErr{ref{l, c}}
+		}
+		return u, lJsonl, nil
 	}
+}
 
-	u, err = remap(u, reflect.TypeOf(v))
-	if err != nil {
-		return err
+// json decodes the next json value from jd, and its origin tree when origins
+// are tracked.
+func (d *decoder) json(jd *json.Decoder) (interface{}, error) {
+	if !d.origins {
+		return decodeJson(jd)
 	}
+	src, line := d.src, d.line
+	u, o, err := decodeJsonAt(jd, func(n int64) Origin {
+		l, _ := offset(src, n)
+		return Origin{d.name, line + l}
+	})
+	d.o = o
+	return u, err
+}
 
-	var bb bytes.Buffer
-	if err := NewEncoder(&bb).AsJson().Encode(u); err != nil {
-		return err
+// originsOf returns the origin tree of value u decoded from l.
+func (d *decoder) originsOf(u interface{}, l lang) interface{} {
+	switch {
+	case d.o != nil:
+		return d.o
+	case l == lYaml && d.node != nil:
+		return yamlOrigins(d.node, u, d.name, d.line)
+	}
+	return origins(u, Origin{Source: d.name})
+}
+
+// fail returns err as a DecodeError, with the position of the error in the
+// source where it is known.
+func (d *decoder) fail(l lang, err error) error {
+	e := &DecodeError{Source: d.name, Cause: err}
+	if l != lUnknown {
+		e.Format = l.String()
+	}
+	var (
+		js *json.SyntaxError
+		jt *json.UnmarshalTypeError
+		xs *xml.SyntaxError
+		cp *csv.ParseError
+		eo *eofErr
+		tp toml.ParseError
+		at interface{ at() ref }
+	)
+	switch {
+	case errors.As(err, &js):
+		e.Line, e.Column = offset(d.src, js.Offset)
+	case errors.As(err, &jt):
+		e.Line, e.Column = offset(d.src, jt.Offset)
+	case errors.As(err, &xs):
+		e.Line = xs.Line
+		e.msg = xs.Msg
+	case errors.As(err, &eo):
+		// the end of input, after the last of the value
+		e.Line, e.Column = offset(d.src, int64(len(bytes.TrimRight(d.src, " \t\r\n"))))
+		e.Column++
+	case errors.As(err, &cp):
+		e.Line, e.Column = cp.Line, cp.Column
+		e.msg = cp.Err.Error()
+	case errors.As(err, &tp):
+		// Start is the byte offset of the token, counting from 0, and of the
+		// byte before a control character
+		n := int64(tp.Position.Start)
+		if strings.Contains(tp.Error(), "cannot contain control characters") {
+			n++
+		}
+		e.Line, e.Column = offset(d.src, n)
+		e.Column++
+		e.msg = tomlMsgRe.ReplaceAllString(tp.Error(), "$2$1")
+	case errors.As(err, &at):
+		r := at.at()
+		e.Line, e.Column = r.l, r.c
+	default:
+		e.Line, e.msg = lineOf(err)
+	}
+	if e.Line > 0 {
+		e.Line += d.line
+	}
+	return e
+}
+
+var (
+	tomlMsgRe  = regexp.MustCompile(`(?s)^toml: line \d+( \(last key ".*?"\))?: (.*)$`)
+	yamlLineRe = regexp.MustCompile(`(?s)^(?:error converting YAML to JSON: )?yaml: line (\d+): (.*)$`)
+)
+
+// lineOf finds a line number in the message of a yaml error, and returns it
+// with the rest of the message.
+func lineOf(err error) (int, string) {
+	s := err.Error()
+	if ms := yamlLineRe.FindStringSubmatch(s); ms != nil {
+		n, _ := strconv.Atoi(ms[1])
+		return n, ms[2]
 	}
+	return 0, ""
+}
 
-	return json.NewDecoder(&bb).Decode(v)
+// offset returns the line and column of byte offset n in b.
+func offset(b []byte, n int64) (int, int) {
+	if n > int64(len(b)) {
+		n = int64(len(b))
+	}
+	if n < 0 {
+		n = 0
+	}
+	l := bytes.Count(b[:n], []byte("\n")) + 1
+	c := int(n) - bytes.LastIndexByte(b[:n], '\n') - 1
+	return l, c
 }
 
-// Decoder reads yaml, json, or toml from one or more readers.  When used
+// Decoder reads yaml, json, toml, or xml from one or more readers.  When used
 // with multiple readers, results from each reader are merged with preference
 // to the right or higher index.
 //
+// Xml elements become maps keyed by child element name, attributes are keys
+// prefixed with "@", text is kept under "#text" and repeated elements become
+// lists. An element with only text is a string.
+//
 // Struct tags labeled "jam" can be employed to decode using jmespath
 // expressions. Struct tags labeled "json" are also respected.
 type Decoder struct {
@@ -118,62 +360,210 @@ type Decoder struct {
 // NewDecoder creates a new Decoder using one or more readers.  When used
 // with multiple readers, results from each reader are merged with preference
 // to the right or higher index.
+//
+// Errors name each reader by its Name method, if it has one like *os.File,
+// otherwise by index.
 func NewDecoder(rs ...io.Reader) *Decoder {
 	ds := make([]*decoder, len(rs))
-	for i := range rs {
-		ds[i] = &decoder{r: rs[i]}
+	for i, r := range rs {
+		ds[i] = &decoder{r: r, name: strconv.Itoa(i)}
+		if n, ok := r.(interface{ Name() string }); ok {
+			ds[i].name = n.Name()
+		}
+	}
+	return &Decoder{ds}
+}
+
+// NewDecoderFormat creates a new Decoder for one or more readers in the named
+// format: yaml, json, jsonl, toml, xml, csv or tsv. Csv and tsv fields are
+// converted to numbers and booleans where possible. An empty format name means
+// the format is detected, like NewDecoder.
+func NewDecoderFormat(format string, rs ...io.Reader) (*Decoder, error) {
+	d := NewDecoder(rs...)
+	if format == "" {
+		return d, nil
+	}
+	l, ok := langs[format]
+	if !ok {
+		return nil, fmt.Errorf("unknown format %q", format)
+	}
+	return d.as(l, true), nil
+}
+
+// AsYaml creates a copy of this Decoder set to decode yaml. Yaml includes
+// json, which is still decoded as json.
+func (d *Decoder) AsYaml() *Decoder {
+	return d.as(lYaml, false)
+}
+
+// AsJson creates a copy of this Decoder set to decode json.
+func (d *Decoder) AsJson() *Decoder {
+	return d.as(lJson, false)
+}
+
+// AsToml creates a copy of this Decoder set to decode toml.
+func (d *Decoder) AsToml() *Decoder {
+	return d.as(lToml, false)
+}
+
+// AsXml creates a copy of this Decoder set to decode xml.
+func (d *Decoder) AsXml() *Decoder {
+	return d.as(lXml, false)
+}
+
+// AsCsv creates a copy of this Decoder set to decode csv. The header row
+// becomes keys and each following row a map. When infer is true, numbers and
+// booleans are converted and empty fields become nil, otherwise every field
+// is a string.
+func (d *Decoder) AsCsv(infer bool) *Decoder {
+	return d.as(lCsv, infer)
+}
+
+// AsTsv creates a copy of this Decoder set to decode tsv. It behaves like
+// AsCsv with tab separated fields.
+func (d *Decoder) AsTsv(infer bool) *Decoder {
+	return d.as(lTsv, infer)
+}
+
+// AsJsonLines creates a copy of this Decoder set to decode newline delimited
+// json, also known as json lines. Values are read from the reader one at a
+// time, the input is never buffered whole.
+func (d *Decoder) AsJsonLines() *Decoder {
+	return d.as(lJsonl, false)
+}
+
+// as creates a copy of this Decoder set to decode lang.
+func (d *Decoder) as(l lang, infer bool) *Decoder {
+	return d.copy(func(c *decoder) { c.lang, c.infer = l, infer })
+}
+
+// UseNumber creates a copy of this Decoder that decodes numbers as
+// json.Number, which keeps integers and decimals of any size and precision
+// intact. The default is float64. Yaml is decoded as yaml 1.2, with full tag
+// support, see Tag.
+func (d *Decoder) UseNumber() *Decoder {
+	return d.copy(func(c *decoder) { c.numbers = true })
+}
+
+// KeepOrder creates a copy of this Decoder that decodes maps as a *Map, with
+// their keys in source order. The default is map[string]interface{}.
+func (d *Decoder) KeepOrder() *Decoder {
+	return d.copy(func(c *decoder) { c.order = true })
+}
+
+// TrackOrigins creates a copy of this Decoder that records where each value
+// of a Doc was set, the name of its reader and its line, see Doc.Origin.
+// Lines are known for yaml, json and json lines. Values merged from several
+// readers keep the origin of the reader that set them last.
+func (d *Decoder) TrackOrigins() *Decoder {
+	return d.copy(func(c *decoder) { c.origins = true })
+}
+
+// Tag creates a copy of this Decoder with a handler for a yaml tag, like
+// "!env". A Decoder with tag handlers decodes yaml with full tag support:
+// standard tags like "!!str" are respected, custom tags are replaced by the
+// result of their handler, and a custom tag without a handler is an error,
+// except "!delete" which is Delete. Anchors, aliases and merge keys are
+// resolved as usual. See EnvTag and FileTag.
+func (d *Decoder) Tag(tag string, fn TagFunc) *Decoder {
+	return d.copy(func(c *decoder) {
+		tags := map[string]TagFunc{tag: fn}
+		for k, v := range c.tags {
+			if k != tag {
+				tags[k] = v
+			}
+		}
+		c.tags = tags
+	})
+}
+
+// copy creates a copy of this Decoder, fn changes the copy of each decoder.
+func (d *Decoder) copy(fn func(*decoder)) *Decoder {
+	ds := make([]*decoder, len(d.ds))
+	for i, u := range d.ds {
+		ds[i] = &decoder{r: u.r, name: u.name, lang: u.lang, infer: u.infer, tags: u.tags, numbers: u.numbers, order: u.order, origins: u.origins}
+		fn(ds[i])
 	}
 	return &Decoder{ds}
 }
 
-// Decode reads one json object, or one yaml document, or toml from each of
-// the Decoder's readers.  When used with multiple readers, results from
+// Decode reads one json object, or one yaml document, or toml, or xml from
+// each of the Decoder's readers.  When used with multiple readers, results from
 // each reader are merged with preference to the right or higher index.
 // The result of the merge is encoded to json and then finally decoded into v.
 // Decode returns ErrNoMore when every reader is exhausted.
 //
 // Struct tags labeled "jam" can be employed to decode using jmespath
 // expressions. Struct tags labeled "json" are also respected.
+//
+// When v is a *Doc, yaml documents are decoded with their comments, key order
+// and styles, see Doc.
 func (d *Decoder) Decode(v interface{}) error {
 	var (
-		mv interface{}
-		nm int
+		mv, mo interface{}
+		md     *Doc
+		nm, nv int
 	)
-	for i, d := range d.ds {
-		var v interface{}
-		err := d.Decode(&v)
+	doc, ok := v.(*Doc)
+	for _, d := range d.ds {
+		var (
+			v interface{}
+			u Doc
+		)
+		var err error
+		if ok {
+			err = d.Decode(&u)
+			v = u.v
+		} else {
+			err = d.Decode(&v)
+		}
 		if IsNoMore(err) {
 			nm++
 			continue
 		}
 		if err != nil {
-			return errSauce{i, err}
+			return err
+		}
+		// the first value is kept as it is, with any Delete values
+		switch {
+		case nv > 0 && u.o != nil:
+			v, u.o = mergeTraced(mv, v, mo, u.o, MergeOptions{})
+		case nv > 0:
+			v = MergeInPlace(mv, v)
+		}
+		mv, nv = v, nv+1
+		if ok {
+			md, mo = md.merge(&u), u.o
 		}
-		mv = Merge(mv, v)
 	}
 	if nm == len(d.ds) {
 		return ErrNoMore{}
 	}
+	if ok {
+		*doc = *md.with(mv)
+		doc.o = mo
+		return nil
+	}
 
-	var bb bytes.Buffer
-	if err := NewEncoder(&bb).AsJson().Encode(mv); err != nil {
-		return err
+	if err := assign(mv, v, d.ds[0].numbers); err != nil {
+		return &DecodeError{Cause: err}
 	}
-	return newDecoder(&bb).Decode(v)
+	return nil
 }
 
-// Encoder writes yaml, json, toml, go syntax, or go struct definition to a
-// writer.  The behaviour depends on the underlying function, which may be set
-// using the AsYaml, AsJson, AsToml, AsGo, and AsStruct methods. The default is
-// yaml.
+// Encoder writes yaml, json, toml, xml, csv, tsv, go syntax, or go struct
+// definition to a writer.  The behaviour depends on the underlying function,
+// which may be set using the AsYaml, AsJson, AsJsonLines, AsToml, AsXml, AsCsv,
+// AsTsv, AsGo, and AsStruct methods. The default is yaml.
 type Encoder struct {
 	w      io.Writer
 	encode func(w io.Writer, v interface{}) error
+	docs   bool
 }
 
 // NewEncoder creates an Encoder set to encode as yaml.
 func NewEncoder(w io.Writer) *Encoder {
-	return &Encoder{w: w, encode: asYaml}
+	return &Encoder{w: w, encode: asYaml, docs: true}
 }
 
 // AsGo creates a copy of this Encoder set to create go syntax.
@@ -188,7 +578,7 @@ func (e *Encoder) AsStruct() *Encoder {
 
 // AsYaml creates a copy of this Encoder set to encode as yaml.
 func (e *Encoder) AsYaml() *Encoder {
-	return &Encoder{w: e.w, encode: asYaml}
+	return &Encoder{w: e.w, encode: asYaml, docs: true}
 }
 
 // AsJson creates a copy of this Encoder set to encode as json.
@@ -201,20 +591,86 @@ func (e *Encoder) AsToml() *Encoder {
 	return &Encoder{w: e.w, encode: asToml}
 }
 
+// AsXml creates a copy of this Encoder set to encode as xml. Keys prefixed
+// with "@" become attributes, "#text" becomes text and lists become repeated
+// elements. A map with one key is the document element, anything else is
+// wrapped in a "jam" element.
+func (e *Encoder) AsXml() *Encoder {
+	return &Encoder{w: e.w, encode: asXml}
+}
+
+// AsJsonLines creates a copy of this Encoder set to encode as newline
+// delimited json, one compact value per line. The items of a list are written
+// on a line each.
+func (e *Encoder) AsJsonLines() *Encoder {
+	return &Encoder{w: e.w, encode: asJsonLines}
+}
+
+// AsCsv creates a copy of this Encoder set to encode as csv. The value is a
+// list of maps, or a single map, each map is a row. Nested values are
+// flattened to dotted column names, list items are addressed by index.
+func (e *Encoder) AsCsv() *Encoder {
+	return &Encoder{w: e.w, encode: asCsv}
+}
+
+// AsTsv creates a copy of this Encoder set to encode as tsv. It behaves like
+// AsCsv with tab separated fields.
+func (e *Encoder) AsTsv() *Encoder {
+	return &Encoder{w: e.w, encode: asTsv}
+}
+
 // Encode writes to the underlying writer.  The behaviour depends on the
-// underlying function, which may be set using the AsYaml, AsJson, AsToml, AsGo,
-// AsStruct methods. The default is yaml.
+// underlying function, which may be set using the AsYaml, AsJson, AsJsonLines,
+// AsToml, AsXml, AsCsv, AsTsv, AsGo, AsStruct methods. The default is yaml.
+//
+// A *Doc is written as yaml with its comments, key order and styles, other
+// encodings write its value.
 func (e *Encoder) Encode(v interface{}) error {
+	if d, ok := v.(*Doc); ok {
+		if e.docs {
+			return d.encode(e.w)
+		}
+		v = d.v
+	}
 	return e.encode(e.w, v)
 }
 
-type errSauce struct {
-	i   int
-	err error
+// DecodeError is returned by decoders when the input can not be decoded.
+// Source names the input, a file name or the index of a reader. Line and
+// Column are where the error was found, counting from 1, or 0 when unknown.
+// Format is the input format and Cause the underlying error.
+type DecodeError struct {
+	Source       string
+	Line, Column int
+	Format       string
+	Cause        error
+
+	msg string
+}
+
+func (e *DecodeError) Error() string {
+	s := ""
+	if e.Source != "" {
+		s += "source " + e.Source + ": "
+	}
+	switch {
+	case e.Line > 0 && e.Column > 0:
+		s += fmt.Sprintf("%d:%d: ", e.Line, e.Column)
+	case e.Line > 0:
+		s += fmt.Sprintf("%d: ", e.Line)
+	}
+	if e.Format != "" {
+		s += e.Format + ": "
+	}
+	if e.msg != "" {
+		return s + e.msg
+	}
+	return s + strings.TrimPrefix(e.Cause.Error(), e.Format+": ")
 }
 
-func (e errSauce) Error() string {
-	return fmt.Sprintf("source %d: %s", e.i, e.err)
+// Unwrap returns the underlying error.
+func (e *DecodeError) Unwrap() error {
+	return e.Cause
 }
 
 // ErrNoMore is returned by the Decoder. It means there was no more to decode.
@@ -261,15 +717,9 @@ func NewFileDecoder(paths ...string) (*FileDecoder, error) {
 // Decode takes one object from each file, merging as it goes. The result
 // is encoded to json and then finally decoded into v. Decoder Decode calls
 // that return ErrNoMore are not merged. Decode returns ErrNoMore when every
-// file is exhausted.
+// file is exhausted. Errors are a *DecodeError naming the file.
 func (d *FileDecoder) Decode(v interface{}) error {
-	err := d.Decoder.Decode(v)
-	if err != nil {
-		if e, ok := err.(errSauce); ok {
-			return fmt.Errorf("source %s: %s", d.fs[e.i].Name(), e.err)
-		}
-	}
-	return err
+	return d.Decoder.Decode(v)
 }
 
 // Close closes open files held by the FileDecoder
@@ -282,34 +732,153 @@ func (d *FileDecoder) Close() error {
 	return nil
 }
 
-// Jam accumulates operations on a data tree.
+// Jam accumulates operations on a data tree. Values may be a *Doc, which
+// keeps its yaml document, see Doc. A Doc with origins has them merged too,
+// see Jam.Origin. Other operations that change a value drop its origins.
 type Jam struct {
 	vs []interface{}
+	ds []*Doc
+	os []interface{}
 }
 
 func (j *Jam) atLeast(length int) {
 	for i := len(j.vs); i < length; i++ {
 		j.vs = append(j.vs, interface{}(nil))
+		j.ds = append(j.ds, nil)
+		j.os = append(j.os, nil)
 	}
 }
 
 // NewJam creates a Jam.
 func NewJam(vs ...interface{}) *Jam {
-	return &Jam{vs}
+	j := &Jam{}
+	j.atLeast(len(vs))
+	for i, v := range vs {
+		if d, ok := v.(*Doc); ok {
+			j.ds[i], j.os[i], v = d, d.o, d.v
+		}
+		j.vs[i] = v
+	}
+	return j
 }
 
 func (j *Jam) Diff(vs ...interface{}) {
+	j.DiffWith(MergeOptions{}, vs...)
+}
+
+// DiffWith diffs like Diff, with lists merged by key as opts say diffed by
+// key, see DiffWith.
+func (j *Jam) DiffWith(opts MergeOptions, vs ...interface{}) {
 	j.atLeast(len(vs))
 	for i, v := range vs {
-		j.vs[i] = Diff(j.vs[i], v)
+		if d, ok := v.(*Doc); ok {
+			v = d.v
+		}
+		j.vs[i], j.os[i] = DiffWith(j.vs[i], v, opts), nil
 	}
 }
 
 func (j *Jam) Merge(vs ...interface{}) {
+	j.MergeWith(MergeOptions{}, vs...)
+}
+
+// MergeWith merges like Merge, with lists merged as opts say, see
+// MergeWith.
+func (j *Jam) MergeWith(opts MergeOptions, vs ...interface{}) {
 	j.atLeast(len(vs))
 	for i, v := range vs {
-		j.vs[i] = Merge(j.vs[i], v)
+		var o interface{}
+		if d, ok := v.(*Doc); ok {
+			j.ds[i], v, o = j.ds[i].merge(d), d.v, d.o
+		}
+		if o != nil || j.os[i] != nil {
+			j.vs[i], j.os[i] = mergeTraced(j.vs[i], v, j.os[i], o, opts)
+			continue
+		}
+		j.vs[i] = MergeWith(j.vs[i], v, opts)
+	}
+}
+
+// Merge3 sets the Jam's values to the three way merges of base, ours and
+// theirs, by index, see Merge3. It returns the conflicts of all of them. A
+// Doc of ours keeps its layout.
+func (j *Jam) Merge3(base, ours, theirs []interface{}) []Conflict {
+	n := len(ours)
+	if len(theirs) > n {
+		n = len(theirs)
+	}
+	j.atLeast(n)
+	value := func(vs []interface{}, i int) interface{} {
+		if i >= len(vs) {
+			return nil
+		}
+		if d, ok := vs[i].(*Doc); ok {
+			return d.v
+		}
+		return vs[i]
+	}
+	cs := []Conflict{}
+	for i := 0; i < n; i++ {
+		if i < len(ours) {
+			if d, ok := ours[i].(*Doc); ok {
+				j.ds[i] = j.ds[i].merge(d)
+			}
+		}
+		v, c := Merge3(value(base, i), value(ours, i), value(theirs, i))
+		j.vs[i], j.os[i], cs = v, nil, append(cs, c...)
+	}
+	return cs
+}
+
+// ApplyPatch applies json patch patch to the Jam's values, see ApplyPatch.
+// On an error the values are unchanged.
+func (j *Jam) ApplyPatch(patch []Op) error {
+	vs := make([]interface{}, len(j.vs))
+	for i, v := range j.vs {
+		var err error
+		if vs[i], err = ApplyPatch(v, patch); err != nil {
+			return err
+		}
+	}
+	j.vs, j.os = vs, make([]interface{}, len(vs))
+	return nil
+}
+
+// Doc returns the Jam's value as a Doc, which keeps the comments, key order
+// and styles of the yaml documents merged into it, and the origins of its
+// values. It returns nil when no yaml document and no origins were merged.
+func (j *Jam) Doc(i int) *Doc {
+	if i >= len(j.ds) {
+		return nil
+	}
+	d := j.ds[i]
+	if d == nil || d.n == nil {
+		if j.os[i] == nil {
+			return nil
+		}
+		d = &Doc{}
 	}
+	u := d.with(j.vs[i])
+	u.o = j.os[i]
+	return u
+}
+
+// Origin returns the origin of the leaf at json pointer path of the Jam's
+// value i, where it was set by a Doc merged with origins, see
+// Decoder.TrackOrigins. It returns false when the leaf is not there or its
+// origin is not known.
+func (j *Jam) Origin(i int, path string) (Origin, bool) {
+	if i >= len(j.vs) {
+		return Origin{}, false
+	}
+	ts, err := pointer(path)
+	if err != nil {
+		return Origin{}, false
+	}
+	if _, err := get(j.vs[i], ts); err != nil {
+		return Origin{}, false
+	}
+	return originAt(j.os[i], path)
 }
 
 func (j *Jam) Exec(dst io.Writer, src io.Reader) error {
@@ -323,46 +892,89 @@ func (j *Jam) Exec(dst io.Writer, src io.Reader) error {
 		return err
 	}
 	for _, v := range j.vs {
-		if err := t.Execute(dst, v); err != nil {
+		if err := t.Execute(dst, plain(v)); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
-// Query applies the Query function to the Jam's value.
-func (j *Jam) Query(q string) {
+// Query applies the Query function to the Jam's value. A query that does
+// not compile or fails is an error, see CompileQuery, and the values are
+// left unchanged.
+func (j *Jam) Query(q string) error {
+	e, err := CompileQuery(q)
+	if err != nil {
+		return err
+	}
+	return j.Search(e)
+}
+
+// Search applies compiled query e to the Jam's value, like Query.
+func (j *Jam) Search(e *QueryExpr) error {
+	var err error
+	vs := make([]interface{}, len(j.vs))
 	for i := range j.vs {
-		j.vs[i] = Query(j.vs[i], q)
+		if vs[i], err = e.Query(j.vs[i]); err != nil {
+			return err
+		}
 	}
+	for i := range j.vs {
+		j.vs[i], j.os[i] = vs[i], nil
+	}
+	return nil
 }
 
-// Filter applies the Filter function to the Jam's value.
-func (j *Jam) Filter(q string) {
+// Filter applies the Filter function to the Jam's value. A path that
+// does not compile is an error, see CompileFilter.
+func (j *Jam) Filter(q string) error {
+	e, err := CompileFilter(q)
+	if err != nil {
+		return err
+	}
 	for i := range j.vs {
-		j.vs[i] = Filter(j.vs[i], q)
+		j.vs[i], j.os[i] = e.Filter(j.vs[i]), nil
 	}
+	return nil
 }
 
-// FilterI applies the FilterI function to the Jam's value.
-func (j *Jam) FilterI(q string) {
+// FilterI applies the FilterI function to the Jam's value. A path that
+// does not compile is an error, see CompileFilter.
+func (j *Jam) FilterI(q string) error {
+	e, err := CompileFilter(q)
+	if err != nil {
+		return err
+	}
 	for i := range j.vs {
-		j.vs[i] = FilterI(j.vs[i], q)
+		j.vs[i], j.os[i] = e.FilterI(j.vs[i]), nil
 	}
+	return nil
 }
 
-// FilterR applies the FilterR function to the Jam's value.
-func (j *Jam) FilterR(q string) {
+// FilterR applies the FilterR function to the Jam's value. A path that
+// does not compile is an error, see CompileFilter.
+func (j *Jam) FilterR(q string) error {
+	e, err := CompileFilter(q)
+	if err != nil {
+		return err
+	}
 	for i := range j.vs {
-		j.vs[i] = FilterR(j.vs[i], q)
+		j.vs[i], j.os[i] = e.FilterR(j.vs[i]), nil
 	}
+	return nil
 }
 
-// FilterIR applies the FilterIR function to the Jam's value.
-func (j *Jam) FilterIR(q string) {
+// FilterIR applies the FilterIR function to the Jam's value. A path that
+// does not compile is an error, see CompileFilter.
+func (j *Jam) FilterIR(q string) error {
+	e, err := CompileFilter(q)
+	if err != nil {
+		return err
+	}
 	for i := range j.vs {
-		j.vs[i] = FilterIR(j.vs[i], q)
+		j.vs[i], j.os[i] = e.FilterIR(j.vs[i]), nil
 	}
+	return nil
 }
 
 // Value returns the Jam's value.
@@ -390,25 +1002,6 @@ func analyze(b []byte) analysis {
 	return *a
 }
 
-// nerrs returns up to n errors from the analysis on separate lines
-func (a *analysis) nerrs(n int) error {
-	lim := len(a.errs)
-	if lim > n {
-		lim = n
-	}
-	s := ""
-	for i := 0; i < lim; i++ {
-		s += a.errs[i].Error() + "\n"
-	}
-	if len(s) > 0 {
-		s = s[:len(s)-1]
-	}
-	if len(a.errs) > lim {
-		s += fmt.Sprintf("\n%d more errors", len(a.errs)-lim)
-	}
-	return errors.New(s)
-}
-
 // hasTab yields a function to find tabs in yaml indent
 func (a *analysis) hasTab() func(byte, ref) {
 	danger := true
@@ -427,11 +1020,17 @@ func (a *analysis) hasTab() func(byte, ref) {
 	}
 }
 
-// hasTag yields a function to find tags in yaml
+// hasTag yields a function to find tags in yaml, other than the standard
+// tags like !!binary.
 func (a *analysis) hasTag() func(byte, ref) {
-	warm, hot := false, true
+	warm, hot, bang := false, true, false
 	return func(c byte, r ref) {
+		std := bang && c == '!'
+		bang = false
 		switch {
+		case std:
+			a.errs = a.errs[:len(a.errs)-1]
+			warm, hot = false, false
 		case (c == ':' || c == '[' || c == '{' || c == ','):
 			warm, hot = true, false
 		case c == '\n':
@@ -444,6 +1043,7 @@ func (a *analysis) hasTag() func(byte, ref) {
 
 		case c == '!':
 			a.errs = append(a.errs, &tagErr{r: r})
+			bang = true
 		default:
 			warm, hot = false, false
 		}
@@ -452,9 +1052,16 @@ func (a *analysis) hasTag() func(byte, ref) {
 
 // isLang yields a function to determine the language of a sample
 func (a *analysis) isLang() func(byte, ref) {
-	var hot bool
+	var hot, seen bool
 	return func(c byte, r ref) {
+		first := !seen && c != ' ' && c != '\t' && c != '\r' && c != '\n'
+		if first {
+			seen = true
+		}
 		switch {
+		case first && c == '<':
+			a.lang = lXml
+		case a.lang == lXml:
 		case c == ':':
 			hot = true
 		case hot && c == ' ':
@@ -475,8 +1082,45 @@ const (
 	lYaml
 	lJson
 	lToml
+	lXml
+	lCsv
+	lTsv
+	lJsonl
 )
 
+// langs are languages by name.
+var langs = map[string]lang{
+	"yaml":   lYaml,
+	"yml":    lYaml,
+	"json":   lJson,
+	"jsonl":  lJsonl,
+	"ndjson": lJsonl,
+	"toml":   lToml,
+	"xml":    lXml,
+	"csv":    lCsv,
+	"tsv":    lTsv,
+}
+
+func (l lang) String() string {
+	switch l {
+	case lYaml:
+		return "yaml"
+	case lJson:
+		return "json"
+	case lJsonl:
+		return "jsonl"
+	case lToml:
+		return "toml"
+	case lXml:
+		return "xml"
+	case lCsv:
+		return "csv"
+	case lTsv:
+		return "tsv"
+	}
+	return "unknown"
+}
+
 // ref is a line and column number
 type ref struct {
 	l, c int
@@ -485,20 +1129,24 @@ type ref struct {
 // tabErr is an error for tabs found in yaml indent
 type tabErr struct{ r ref }
 
-func (e *tabErr) Error() string {
-	return fmt.Sprintf("%d:%d: yaml: tab indents are not valid", e.r.l, e.r.c)
-}
+func (e *tabErr) Error() string { return "tab indents are not valid" }
+func (e *tabErr) at() ref       { return e.r }
+
+// moreErr is an error for more than one value on a json line
+type moreErr struct{ r ref }
+
+func (e *moreErr) Error() string { return "more than one value on the line" }
+func (e *moreErr) at() ref       { return e.r }
 
 // tagErr is an error for tags found in yaml
 type tagErr struct{ r ref }
 
-func (e *tagErr) Error() string {
-	return fmt.Sprintf("%d:%d: yaml: tags are not supported", e.r.l, e.r.c)
-}
+func (e *tagErr) Error() string { return "tags are not supported" }
+func (e *tagErr) at() ref       { return e.r }
 
 // asGo writes formatted go syntax to w
 func asGo(w io.Writer, v interface{}) error {
-	s := fmt.Sprintf("%+#v\n", v)
+	s := fmt.Sprintf("%+#v\n", leaves(plain(v), goValue))
 	t := ""
 	var escape, bquote, squote, dquote bool
 	for i, c := range s {
@@ -543,11 +1191,26 @@ func asJson(w io.Writer, v interface{}) error {
 	return enc.Encode(v)
 }
 
+// asJsonLines writes json lines to w, list items are written a line each.
+func asJsonLines(w io.Writer, v interface{}) error {
+	enc := json.NewEncoder(w)
+	vs, ok := v.([]interface{})
+	if !ok {
+		return enc.Encode(v)
+	}
+	for _, v := range vs {
+		if err := enc.Encode(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // asStruct writes a formatted go struct definition to w.
 func asStruct(w io.Writer, v interface{}) error {
 	bb := bytes.NewBuffer([]byte{})
 	io.WriteString(bb, "type T ")
-	goStruct(bb, v)
+	goStruct(bb, leaves(plain(v), native))
 	io.WriteString(bb, "\n")
 
 	b, err := format.Source(bb.Bytes())
@@ -558,23 +1221,79 @@ func asStruct(w io.Writer, v interface{}) error {
 	return err
 }
 
-// asToml writes toml to w.
+// asToml writes toml to w. Binary values are written in base64, nil is an
+// empty document. Keys of a *Map are written in order, tables after values
+// as toml requires. A decimal that a float64 would change is written as it
+// is, an integer that is not 64 bit is an error.
 func asToml(w io.Writer, y interface{}) error {
-	return toml.NewEncoder(w).Encode(y)
+	if y == nil {
+		return nil
+	}
+	var err error
+	v := tomlOrder(leaves(y, func(v interface{}) interface{} {
+		switch v := v.(type) {
+		case []byte:
+			return text(v)
+		case json.Number:
+			u, ok := exactly(v)
+			if _, big := u.(uint64); ok && !big {
+				return u
+			}
+			// toml integers are 64 bit, and floats are binary64
+			if _, e := v.Float64(); e != nil || !tomlFloatRe.MatchString(string(v)) || !strings.ContainsAny(string(v), ".eE") {
+				err = fmt.Errorf("toml can not hold the number %s", v)
+			}
+			return tomlNumber(v)
+		}
+		return v
+	}))
+	if err != nil {
+		return err
+	}
+	return toml.NewEncoder(w).Encode(v)
 }
 
-// asYaml writes yaml to w.
+var tomlFloatRe = regexp.MustCompile(`^[+-]?(0|[1-9][0-9]*)(\.[0-9]+)?([eE][+-]?[0-9]+)?$`)
+
+// tomlNumber is a decimal written as it is.
+type tomlNumber json.Number
+
+// MarshalTOML writes the number as a toml float.
+func (n tomlNumber) MarshalTOML() ([]byte, error) { return []byte(n), nil }
+
+// asYaml writes yaml to w, by way of json so json struct tags are respected.
 func asYaml(w io.Writer, v interface{}) error {
-	b, err := yaml.Marshal(v)
-	if err != nil {
+	var u interface{}
+	if err := assign(v, &u, true); err != nil {
 		return err
 	}
-	ds := []byte("---\n")
-	if !bytes.HasPrefix(b, ds) {
-		b = append(ds, b...)
+	if _, err := io.WriteString(w, "---\n"); err != nil {
+		return err
+	}
+	e := yaml3.NewEncoder(w)
+	e.SetIndent(2)
+	if err := e.Encode(yamlNode(u)); err != nil {
+		return err
+	}
+	return e.Close()
+}
+
+// scalar formats a scalar as text.
+func scalar(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case json.Number:
+		return string(v)
+	case time.Time, []byte:
+		return text(v).(string)
+	default:
+		return fmt.Sprint(v)
 	}
-	_, err = w.Write(b)
-	return err
 }
 
 // bloop calls funcs with each byte of b that is unquoted and unescaped,
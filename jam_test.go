@@ -2,12 +2,15 @@ package jam
 
 import (
 	"bytes"
+	"encoding/json"
+	"errors"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 )
 
 type _m = map[string]interface{}
@@ -233,6 +236,9 @@ func TestLang(t *testing.T) {
 		{"foo = 1979-05-27T00:32:00-07:00", lToml},
 		{"foo = 1979-05-27T00:32:00.999999-07:00", lToml},
 		{"foo = 1979-05-27 07:32:00Z", lToml},
+		{"<foo>baz</foo>", lXml},
+		{"\n  <?xml version=\"1.0\"?><foo/>", lXml},
+		{"foo: <baz>", lYaml},
 	}
 	for _, s := range ss {
 		if o := analyze([]byte(s.i)).lang; o != s.x {
@@ -256,7 +262,7 @@ func TestTag(t *testing.T) {
 	}
 
 	ss = []string{
-		"'!'", "foo: baz", "foo: baz !baz", "foo !baz: baz", "foo!baz: baz", "foo: \"!baz\"",
+		"'!'", "foo: baz", "foo: baz !baz", "foo !baz: baz", "foo!baz: baz", "foo: \"!baz\"", "foo: !!binary aGk=", "[ !!str 1 ]",
 	}
 	for _, s := range ss {
 		a := analyze([]byte(s))
@@ -288,3 +294,340 @@ func TestTab(t *testing.T) {
 		}
 	}
 }
+
+func TestJsonLines(t *testing.T) {
+	s := "{\"a\":1}\n{\"a\":2}\n\n[3]\n"
+	x := []interface{}{_m{"a": 1.0}, _m{"a": 2.0}, _s{3.0}}
+	d := NewDecoder(strings.NewReader(s)).AsJsonLines()
+	vs := []interface{}{}
+	for {
+		var v interface{}
+		err := d.Decode(&v)
+		if IsNoMore(err) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		vs = append(vs, v)
+	}
+	if !reflect.DeepEqual(vs, x) {
+		t.Errorf("expected %#v, got %#v", x, vs)
+	}
+
+	bb := bytes.NewBuffer([]byte{})
+	if err := NewEncoder(bb).AsJsonLines().Encode(vs); err != nil {
+		t.Error(err)
+	}
+	if err := NewEncoder(bb).AsJsonLines().Encode(_m{"b": true}); err != nil {
+		t.Error(err)
+	}
+	o := "{\"a\":1}\n{\"a\":2}\n[3]\n{\"b\":true}\n"
+	if bb.String() != o {
+		t.Errorf("expected %q, got %q", o, bb.String())
+	}
+
+	d = NewDecoder(strings.NewReader("{\"a\":1}\n{a: 2}\n")).AsJsonLines()
+	var v interface{}
+	if err := d.Decode(&v); err != nil {
+		t.Error(err)
+	}
+	if err := d.Decode(&v); err == nil {
+		t.Error("expected error got none")
+	}
+}
+
+func TestDecodeFormat(t *testing.T) {
+	var ss = []struct {
+		f, i string
+		x    interface{}
+	}{
+		{"", "- a=b\n", nil},
+		{"yaml", "- a=b\n", _s{"a=b"}},
+		{"yml", "{\"a\":1}", _m{"a": 1.0}},
+		{"", "# note: blep\na = 1\n", "a = 1"},
+		{"toml", "# note: blep\na = 1\n", _m{"a": 1.0}},
+		{"json", "{\"a\":1}", _m{"a": 1.0}},
+		{"xml", "<a>1</a>", _m{"a": "1"}},
+		{"csv", "a\n1\n", _s{_m{"a": 1.0}}},
+	}
+	for _, s := range ss {
+		d, err := NewDecoderFormat(s.f, strings.NewReader(s.i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var v interface{}
+		err = d.Decode(&v)
+		switch {
+		case s.x == nil && err == nil:
+			t.Errorf("%s %q: expected error got none", s.f, s.i)
+		case s.x != nil && err != nil:
+			t.Errorf("%s %q: %s", s.f, s.i, err)
+		case s.x != nil && !reflect.DeepEqual(v, s.x):
+			t.Errorf("%s %q: expected %#v, got %#v", s.f, s.i, s.x, v)
+		}
+	}
+
+	if _, err := NewDecoderFormat("blep"); err == nil {
+		t.Error("expected error got none")
+	}
+}
+
+func TestDecodeFormatErr(t *testing.T) {
+	var ss = []struct {
+		d      *Decoder
+		prefix string
+	}{
+		{NewDecoder(strings.NewReader("a = ")).AsToml(), "toml: "},
+		{NewDecoder(strings.NewReader("{")).AsJson(), "json: "},
+		{NewDecoder(strings.NewReader("<a>")).AsXml(), "xml: "},
+		{NewDecoder(strings.NewReader("a: [")).AsYaml(), "yaml: "},
+	}
+	for _, s := range ss {
+		var v interface{}
+		err := s.d.Decode(&v)
+		if err == nil || !strings.Contains(err.Error(), s.prefix) {
+			t.Errorf("expected %q in error, got %v", s.prefix, err)
+		}
+	}
+}
+
+func TestDecodeError(t *testing.T) {
+	var ss = []struct {
+		f, i   string
+		l, c   int
+		format string
+	}{
+		{"", "a: 1\nb: [\n", 2, 0, "yaml"},
+		{"", "---\na: 1\n---\nb: 2\nc: [\n", 5, 0, "yaml"},
+		{"", "a: 1\n---\nb:\n\tc: 1\n", 4, 1, "yaml"},
+		{"", "a: !x 1", 1, 4, "yaml"},
+		{"", "a = 1\nb = \n", 2, 5, "toml"},
+		{"", "a = 1\na = 2\n", 2, 1, "toml"},
+		{"toml", "\x01", 1, 1, "toml"},
+		{"toml", "\r0", 1, 1, "toml"},
+		{"toml", "a = 1\n\x01", 2, 1, "toml"},
+		{"toml", "a = \"x\x01\"", 1, 7, "toml"},
+		{"json", "{\"a\":\n  1,,}", 2, 5, "json"},
+		{"json", "{\"a\":1}\n{\"b\":", 2, 6, "json"},
+		{"json", "[1,\n tru", 2, 5, "json"},
+		{"json", "[1,\n", 1, 4, "json"},
+		{"jsonl", "{\"a\":1}\n\n{\"a\":,}\n", 3, 6, "jsonl"},
+		{"jsonl", "{\"a\":1} {\"a\":2}\n", 1, 9, "jsonl"},
+		{"jsonl", "{\"a\":1}\n{\"a\":\n1}\n", 2, 6, "jsonl"},
+		{"jsonl", "{\"a\":1}\n[1]  x\n", 2, 6, "jsonl"},
+		{"", "<a>\n<b></c></a>", 2, 0, "xml"},
+		{"csv", "a,b\n1,2\n3\n", 3, 1, "csv"},
+		{"csv", "a,b,a\n1,2,3\n", 1, 5, "csv"},
+	}
+	for _, s := range ss {
+		d, _ := NewDecoderFormat(s.f, strings.NewReader(s.i))
+		var (
+			v   interface{}
+			err error
+		)
+		for err == nil {
+			err = d.Decode(&v)
+		}
+		var e *DecodeError
+		if !errors.As(err, &e) {
+			t.Errorf("%q: expected a DecodeError, got %v", s.i, err)
+			continue
+		}
+		if e.Source != "0" || e.Line != s.l || e.Column != s.c || e.Format != s.format {
+			t.Errorf("%q: expected 0 %d:%d %s, got %s %d:%d %s", s.i, s.l, s.c, s.format, e.Source, e.Line, e.Column, e.Format)
+		}
+	}
+}
+
+func TestFileDecodeError(t *testing.T) {
+	d, err := NewFileDecoder(filepath.Join("testdata", "a.yml"), filepath.Join("testdata", "a.struct"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	var v interface{}
+	err = d.Decode(&v)
+	var e *DecodeError
+	if !errors.As(err, &e) {
+		t.Fatalf("expected a DecodeError, got %v", err)
+	}
+	if e.Source != filepath.Join("testdata", "a.struct") || e.Line == 0 {
+		t.Errorf("expected testdata/a.struct with a line, got %s", e)
+	}
+}
+
+func TestUseNumber(t *testing.T) {
+	var ss = []struct {
+		f, i string
+		x    interface{}
+	}{
+		{"json", `{"a":12345678901234567891,"b":0.1000000000000000055511151231257827}`, _m{
+			"a": json.Number("12345678901234567891"),
+			"b": json.Number("0.1000000000000000055511151231257827"),
+		}},
+		{"jsonl", `{"a":9007199254740993}`, _m{"a": json.Number("9007199254740993")}},
+		{"yaml", "a: 12345678901234567891\nb: 1.50\nc: 0x10\nd: '1'", _m{
+			"a": json.Number("12345678901234567891"),
+			"b": json.Number("1.50"),
+			"c": json.Number("16"),
+			"d": "1",
+		}},
+		{"toml", "a = 9007199254740993\nb = 1.5", _m{"a": json.Number("9007199254740993"), "b": json.Number("1.5")}},
+		{"csv", "a,b\n12345678901234567891,007", _s{_m{"a": json.Number("12345678901234567891"), "b": "007"}}},
+	}
+	for _, s := range ss {
+		d, err := NewDecoderFormat(s.f, strings.NewReader(s.i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var v interface{}
+		if err := d.UseNumber().Decode(&v); err != nil {
+			t.Errorf("%s: %s", s.f, err)
+			continue
+		}
+		if !reflect.DeepEqual(v, s.x) {
+			t.Errorf("%s: expected %#v, got %#v", s.f, s.x, v)
+		}
+	}
+}
+
+func TestEncodeNumber(t *testing.T) {
+	v := _m{"a": json.Number("12345678901234567891"), "b": json.Number("1.50")}
+	var ss = []struct {
+		e func(*Encoder) *Encoder
+		x string
+	}{
+		{(*Encoder).AsJson, `{"a":12345678901234567891,"b":1.50}` + "\n"},
+		{(*Encoder).AsYaml, "---\na: 12345678901234567891\nb: 1.50\n"},
+		{(*Encoder).AsCsv, "a,b\n12345678901234567891,1.50\n"},
+		{(*Encoder).AsStruct, "type T struct {\n\tA uint64  `json:\"a\"`\n\tB float64 `json:\"b\"`\n}\n"},
+	}
+	for _, s := range ss {
+		var bb bytes.Buffer
+		if err := s.e(NewEncoder(&bb)).Encode(v); err != nil {
+			t.Error(err)
+			continue
+		}
+		if bb.String() != s.x {
+			t.Errorf("expected\n%s\ngot\n%s", s.x, bb.String())
+		}
+	}
+}
+
+func TestEncodeExact(t *testing.T) {
+	v := om(
+		"a", json.Number("9223372036854775807"),
+		"b", json.Number("0.1000000000000000055511151231257827"),
+		"c", json.Number("1.50"),
+		"d", json.Number("12345678901234567890123.5"),
+		"e", json.Number("0.1"),
+	)
+	var ss = []struct {
+		e func(*Encoder) *Encoder
+		x string
+	}{
+		{(*Encoder).AsToml, "a = 9223372036854775807\nb = 0.1000000000000000055511151231257827\nc = 1.5\nd = 12345678901234567890123.5\ne = 0.1\n"},
+		{(*Encoder).AsGo, "map[string]interface{}{\n\t\"a\": 9223372036854775807,\n\t\"b\": json.Number(\"0.1000000000000000055511151231257827\"),\n"},
+	}
+	for _, s := range ss {
+		var bb bytes.Buffer
+		if err := s.e(NewEncoder(&bb)).Encode(v); err != nil {
+			t.Error(err)
+			continue
+		}
+		if !strings.HasPrefix(bb.String(), s.x) {
+			t.Errorf("expected\n%s\ngot\n%s", s.x, bb.String())
+		}
+	}
+	for _, n := range []string{"12345678901234567891", "12345678901234567890123", "1e400"} {
+		var bb bytes.Buffer
+		if err := NewEncoder(&bb).AsToml().Encode(_m{"a": json.Number(n)}); err == nil {
+			t.Errorf("%s: expected an error, got %q", n, bb.String())
+		}
+	}
+}
+
+func TestTyped(t *testing.T) {
+	z := time.FixedZone("", -8*3600)
+	var ss = []struct {
+		i string
+		x interface{}
+	}{
+		{"a = 1979-05-27T07:32:00-08:00", time.Date(1979, 5, 27, 7, 32, 0, 0, z)},
+		{"a = 1979-05-27T07:32:00.5", LocalDateTime("1979-05-27T07:32:00.5")},
+		{"a = 1979-05-27", LocalDate("1979-05-27")},
+		{"a = 07:32:00", LocalTime("07:32:00")},
+		{"a: 1979-05-27T07:32:00-08:00", time.Date(1979, 5, 27, 7, 32, 0, 0, z)},
+		{"a: 1979-05-27 07:32:00.5", LocalDateTime("1979-05-27T07:32:00.5")},
+		{"a: 1979-05-27", LocalDate("1979-05-27")},
+		{"a: !!binary aGk=", []byte("hi")},
+		{"a: '1979-05-27'", "1979-05-27"},
+	}
+	for _, s := range ss {
+		var v interface{}
+		if err := NewDecoder(strings.NewReader(s.i)).Decode(&v); err != nil {
+			t.Errorf("%s: %s", s.i, err)
+			continue
+		}
+		if u := v.(_m)["a"]; !equal(u, s.x) {
+			t.Errorf("%s: expected %#v, got %#v", s.i, s.x, u)
+		}
+	}
+}
+
+func TestEncodeTyped(t *testing.T) {
+	v := _m{
+		"d":  LocalDate("1979-05-27"),
+		"dt": LocalDateTime("1979-05-27T07:32:00"),
+		"t":  LocalTime("07:32:00"),
+		"ts": time.Date(1979, 5, 27, 7, 32, 0, 0, time.UTC),
+		"b":  []byte("hi"),
+	}
+	var ss = []struct {
+		e func(*Encoder) *Encoder
+		x string
+	}{
+		{(*Encoder).AsJson, `{"b":"aGk=","d":"1979-05-27","dt":"1979-05-27T07:32:00","t":"07:32:00","ts":"1979-05-27T07:32:00Z"}` + "\n"},
+		{(*Encoder).AsYaml, "---\nb: !!binary aGk=\nd: 1979-05-27\ndt: 1979-05-27 07:32:00\nt: \"07:32:00\"\nts: 1979-05-27T07:32:00Z\n"},
+		{(*Encoder).AsToml, "b = \"aGk=\"\nd = 1979-05-27\ndt = 1979-05-27T07:32:00\nt = 07:32:00\nts = 1979-05-27T07:32:00Z\n"},
+		{(*Encoder).AsCsv, "b,d,dt,t,ts\naGk=,1979-05-27,1979-05-27T07:32:00,07:32:00,1979-05-27T07:32:00Z\n"},
+		{(*Encoder).AsStruct, "type T struct {\n\tB  []byte    `json:\"b\"`\n\tD  string    `json:\"d\"`\n\tDt string    `json:\"dt\"`\n\tT  string    `json:\"t\"`\n\tTs time.Time `json:\"ts\"`\n}\n"},
+	}
+	for _, s := range ss {
+		var bb bytes.Buffer
+		if err := s.e(NewEncoder(&bb)).Encode(v); err != nil {
+			t.Error(err)
+			continue
+		}
+		if bb.String() != s.x {
+			t.Errorf("expected\n%s\ngot\n%s", s.x, bb.String())
+		}
+	}
+}
+
+func TestTypedRoundTrip(t *testing.T) {
+	i := "b = \"x\"\nd = 1979-05-27\ndt = 1979-05-27T07:32:00.5\nts = 1979-05-27T07:32:00-08:00\n"
+	var v interface{}
+	if err := NewDecoder(strings.NewReader(i)).Decode(&v); err != nil {
+		t.Fatal(err)
+	}
+	var yb bytes.Buffer
+	if err := NewEncoder(&yb).AsYaml().Encode(v); err != nil {
+		t.Fatal(err)
+	}
+	var u interface{}
+	if err := NewDecoder(&yb).Decode(&u); err != nil {
+		t.Fatal(err)
+	}
+	if d := Diff(v, u); d != nil {
+		t.Errorf("expected no diff, got %#v", d)
+	}
+	var tb bytes.Buffer
+	if err := NewEncoder(&tb).AsToml().Encode(u); err != nil {
+		t.Fatal(err)
+	}
+	if tb.String() != i {
+		t.Errorf("expected\n%s\ngot\n%s", i, tb.String())
+	}
+}
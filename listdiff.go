@@ -0,0 +1,429 @@
+package jam
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// pair is an item of a list a matched to an item of a list b, by index.
+type pair struct{ a, b int }
+
+// match matches the items of lists a and b, in the order of b. With key k,
+// map items with equal values of k match. Otherwise equal items match, the
+// most that are in order by a longest common subsequence and then any others,
+// and then items left between the same matches in order pair up as changed.
+func match(a, b []interface{}, k string) []pair {
+	ma, mb := make([]int, len(a)), make([]int, len(b))
+	for i := range ma {
+		ma[i] = -1
+	}
+	for j := range mb {
+		mb[j] = -1
+	}
+	link := func(i, j int) { ma[i], mb[j] = j, i }
+	var cs classes
+	if k != "" {
+		// a indices by the class of their key, in order
+		is := map[int][]int{}
+		for i, u := range a {
+			if mu, ok := toMap(u); ok {
+				if y, ok := mu.Get(k); ok {
+					c := cs.of(y)
+					is[c] = append(is[c], i)
+				}
+			}
+		}
+		for j, v := range b {
+			if mv, ok := toMap(v); ok {
+				if x, ok := mv.Get(k); ok {
+					if c := cs.of(x); len(is[c]) > 0 {
+						link(is[c][0], j)
+						is[c] = is[c][1:]
+					}
+				}
+			}
+		}
+	} else {
+		ca, cb := make([]int, len(a)), make([]int, len(b))
+		for i, u := range a {
+			ca[i] = cs.of(u)
+		}
+		for j, v := range b {
+			cb[j] = cs.of(v)
+		}
+		anchors := lcs(ca, cb)
+		for _, p := range anchors {
+			link(p.a, p.b)
+		}
+		// equal items out of order
+		is := map[int][]int{}
+		for i, c := range ca {
+			if ma[i] < 0 {
+				is[c] = append(is[c], i)
+			}
+		}
+		for j, c := range cb {
+			if mb[j] < 0 && len(is[c]) > 0 {
+				link(is[c][0], j)
+				is[c] = is[c][1:]
+			}
+		}
+		// items left in the same gap between anchors
+		i, j := 0, 0
+		for _, p := range append(anchors, pair{len(a), len(b)}) {
+			for i < p.a && j < p.b {
+				switch {
+				case ma[i] >= 0:
+					i++
+				case mb[j] >= 0:
+					j++
+				default:
+					link(i, j)
+				}
+			}
+			i, j = p.a+1, p.b+1
+		}
+	}
+	ps := []pair{}
+	for j, i := range mb {
+		if i >= 0 {
+			ps = append(ps, pair{i, j})
+		}
+	}
+	return ps
+}
+
+// classes numbers values, equal values have the same number. Values are
+// compared only to those with the same digest.
+type classes struct {
+	ds map[uint64][]int
+	vs []interface{}
+}
+
+// of returns the number of v.
+func (cs *classes) of(v interface{}) int {
+	if cs.ds == nil {
+		cs.ds = map[uint64][]int{}
+	}
+	d := digest(v)
+	for _, c := range cs.ds[d] {
+		if equal(cs.vs[c], v) {
+			return c
+		}
+	}
+	cs.vs = append(cs.vs, v)
+	cs.ds[d] = append(cs.ds[d], len(cs.vs)-1)
+	return len(cs.vs) - 1
+}
+
+// digest returns a hash of v that equal values share.
+func digest(v interface{}) uint64 {
+	h := fnv.New64a()
+	v = bare(v)
+	if m, ok := toMap(v); ok {
+		// the sum does not depend on the order of the keys
+		var sum uint64
+		for k, u := range m.m {
+			e := fnv.New64a()
+			io.WriteString(e, k)
+			binary.Write(e, binary.LittleEndian, digest(u))
+			sum += e.Sum64()
+		}
+		io.WriteString(h, "m")
+		binary.Write(h, binary.LittleEndian, sum)
+		return h.Sum64()
+	}
+	switch u := v.(type) {
+	case []interface{}:
+		io.WriteString(h, "l")
+		for _, w := range u {
+			binary.Write(h, binary.LittleEndian, digest(w))
+		}
+	case string:
+		io.WriteString(h, "s"+u)
+	case time.Time:
+		io.WriteString(h, "t"+u.UTC().Format(time.RFC3339Nano))
+	default:
+		// numbers by the nearest float64, which equal numbers share
+		f, ok := 0.0, true
+		switch u := v.(type) {
+		case float64:
+			f, ok = u, !math.IsNaN(u)
+		case int:
+			f = float64(u)
+		case int64:
+			f = float64(u)
+		case json.Number:
+			var err error
+			f, err = strconv.ParseFloat(string(u), 64)
+			ok = err == nil || errors.Is(err, strconv.ErrRange)
+		default:
+			ok = false
+		}
+		if !ok {
+			fmt.Fprintf(h, "%T", v)
+			break
+		}
+		if f == 0 {
+			f = 0 // not -0
+		}
+		io.WriteString(h, "n")
+		binary.Write(h, binary.LittleEndian, f)
+	}
+	return h.Sum64()
+}
+
+// lcs returns the pairs of equal items of a longest common subsequence of
+// lists a and b, of item classes. Items that are not in the other list are
+// left out. When there are few pairs of equal items the rest are compared by
+// Hunt and Szymanski's algorithm, otherwise by Myers' in linear space.
+func lcs(a, b []int) []pair {
+	l := newDiffer(a, b)
+	if ps, ok := l.sparse(8 * (len(l.a) + len(l.b))); ok {
+		return ps
+	}
+	return l.myers()
+}
+
+// differ finds a longest common subsequence of lists a and b, of which ia and
+// ib are the indices in the lists they were taken from. The pairs are added
+// to ps in order, vf and vb are the furthest reaching paths by diagonal.
+type differ struct {
+	a, b, ia, ib []int
+	vf, vb       []int
+	ps           []pair
+}
+
+// newDiffer returns a differ of the items of lists a and b that are in the
+// other list too.
+func newDiffer(a, b []int) *differ {
+	in := func(s []int) map[int]bool {
+		m := make(map[int]bool, len(s))
+		for _, c := range s {
+			m[c] = true
+		}
+		return m
+	}
+	ina, inb := in(a), in(b)
+	l := &differ{ps: []pair{}}
+	for i, c := range a {
+		if inb[c] {
+			l.a, l.ia = append(l.a, c), append(l.ia, i)
+		}
+	}
+	for j, c := range b {
+		if ina[c] {
+			l.b, l.ib = append(l.b, c), append(l.ib, j)
+		}
+	}
+	return l
+}
+
+// myers returns the pairs of a longest common subsequence of a and b by
+// Myers' algorithm, in linear space.
+func (l *differ) myers() []pair {
+	n := len(l.a) + len(l.b) + 1
+	l.vf, l.vb = make([]int, 2*n+3), make([]int, 2*n+3)
+	l.walk(0, len(l.a), 0, len(l.b))
+	return l.ps
+}
+
+// sparse returns the pairs of a longest common subsequence of a and b, the
+// longest increasing subsequence of the b indices of the pairs of equal
+// items, in the order of a and the b indices of each a item decreasing. Of
+// those it favours later items of a and earlier items of b. It is false
+// when there are more than max pairs of equal items.
+func (l *differ) sparse(max int) ([]pair, bool) {
+	js := map[int][]int{}
+	for j, c := range l.b {
+		js[c] = append(js[c], j)
+	}
+	r := 0
+	for _, c := range l.a {
+		if r += len(js[c]); r > max {
+			return nil, false
+		}
+	}
+	ms := make([]pair, 0, r)
+	for i, c := range l.a {
+		for k := len(js[c]) - 1; k >= 0; k-- {
+			ms = append(ms, pair{i, js[c][k]})
+		}
+	}
+	// tails[n] is the pair that ends an increasing subsequence of length n+1
+	// with the least b index, prev the pair before each
+	tails, prev := []int{}, make([]int, len(ms))
+	for k, m := range ms {
+		n := sort.Search(len(tails), func(n int) bool { return ms[tails[n]].b >= m.b })
+		prev[k] = -1
+		if n > 0 {
+			prev[k] = tails[n-1]
+		}
+		if n == len(tails) {
+			tails = append(tails, k)
+		} else {
+			tails[n] = k
+		}
+	}
+	ps := make([]pair, len(tails))
+	if len(tails) == 0 {
+		return ps, true
+	}
+	for n, k := len(ps)-1, tails[len(tails)-1]; n >= 0; n, k = n-1, prev[k] {
+		ps[n] = pair{l.ia[ms[k].a], l.ib[ms[k].b]}
+	}
+	return ps, true
+}
+
+// walk adds the pairs of a[i0:i1] and b[j0:j1].
+func (l *differ) walk(i0, i1, j0, j1 int) {
+	for i0 < i1 && j0 < j1 && l.a[i0] == l.b[j0] {
+		l.ps = append(l.ps, pair{l.ia[i0], l.ib[j0]})
+		i0, j0 = i0+1, j0+1
+	}
+	e := 0
+	for i0 < i1-e && j0 < j1-e && l.a[i1-1-e] == l.b[j1-1-e] {
+		e++
+	}
+	// without a middle snake, which there always is, the items between are
+	// left unpaired
+	if i0 < i1-e && j0 < j1-e {
+		if x, y, u, v, ok := l.snake(i0, i1-e, j0, j1-e); ok {
+			l.walk(i0, x, j0, y)
+			for ; x < u; x, y = x+1, y+1 {
+				l.ps = append(l.ps, pair{l.ia[x], l.ib[y]})
+			}
+			l.walk(u, i1-e, v, j1-e)
+		}
+	}
+	for ; e > 0; e-- {
+		l.ps = append(l.ps, pair{l.ia[i1-e], l.ib[j1-e]})
+	}
+}
+
+// snake returns the middle snake of a[i0:i1] and b[j0:j1], from x, y to u, v,
+// the diagonal part of a shortest edit script half way through it, and
+// whether it found it.
+func (l *differ) snake(i0, i1, j0, j1 int) (x, y, u, v int, ok bool) {
+	n, m := i1-i0, j1-j0
+	delta, max := n-m, (n+m+1)/2
+	// vf[o+k] is the furthest x on diagonal k = x-y from the start, vb[o+k]
+	// the furthest from the end on diagonal k of the lists reversed
+	o := max + 1
+	l.vf[o+1], l.vb[o+1] = 0, 0
+	for d := 0; d <= max; d++ {
+		for k := -d; k <= d; k += 2 {
+			x := l.vf[o+k+1]
+			if k != -d && (k == d || l.vf[o+k-1] >= l.vf[o+k+1]) {
+				x = l.vf[o+k-1] + 1
+			}
+			y := x - k
+			sx, sy := x, y
+			for x < n && y < m && l.a[i0+x] == l.b[j0+y] {
+				x, y = x+1, y+1
+			}
+			l.vf[o+k] = x
+			if r := delta - k; delta%2 != 0 && r >= -(d-1) && r <= d-1 && x+l.vb[o+r] >= n {
+				return i0 + sx, j0 + sy, i0 + x, j0 + y, true
+			}
+		}
+		for k := -d; k <= d; k += 2 {
+			x := l.vb[o+k+1]
+			if k != -d && (k == d || l.vb[o+k-1] >= l.vb[o+k+1]) {
+				x = l.vb[o+k-1] + 1
+			}
+			y := x - k
+			sx, sy := x, y
+			for x < n && y < m && l.a[i1-1-x] == l.b[j1-1-y] {
+				x, y = x+1, y+1
+			}
+			l.vb[o+k] = x
+			if f := delta - k; delta%2 == 0 && f >= -d && f <= d && x+l.vf[o+f] >= n {
+				return i1 - x, j1 - y, i1 - sx, j1 - sy, true
+			}
+		}
+	}
+	return 0, 0, 0, 0, false
+}
+
+// moved returns the b indices of the pairs ps, in the order of b, that are
+// out of the order of a. They are the fewest that leave the others in order,
+// and of those the ones that leave the others least out of place.
+func moved(ps []pair) map[int]bool {
+	mv := map[int]bool{}
+	if len(ps) == 0 {
+		return mv
+	}
+	// n and d are the length and the displacement of the best increasing
+	// subsequence of the a indices ending at each pair, prev the pair before
+	shift := func(p pair) int {
+		if p.a > p.b {
+			return p.a - p.b
+		}
+		return p.b - p.a
+	}
+	n, d, prev := make([]int, len(ps)), make([]int, len(ps)), make([]int, len(ps))
+	better := func(q, k int) bool {
+		return k < 0 || n[q] > n[k] || n[q] == n[k] && (d[q] < d[k] || d[q] == d[k] && q < k)
+	}
+	// t is a fenwick tree of the best pair by a index, for the best pair of
+	// those with a smaller a index
+	size := 0
+	for _, p := range ps {
+		if p.a >= size {
+			size = p.a + 1
+		}
+	}
+	t := make([]int, size+1)
+	for i := range t {
+		t[i] = -1
+	}
+	best := 0
+	for k, p := range ps {
+		q := -1
+		for i := p.a; i > 0; i -= i & -i {
+			if t[i] >= 0 && better(t[i], q) {
+				q = t[i]
+			}
+		}
+		n[k], d[k], prev[k] = 1, shift(p), -1
+		if q >= 0 {
+			n[k], d[k], prev[k] = n[q]+1, d[q]+shift(p), q
+		}
+		for i := p.a + 1; i <= size; i += i & -i {
+			if better(k, t[i]) {
+				t[i] = k
+			}
+		}
+		if n[k] > n[best] || n[k] == n[best] && d[k] < d[best] {
+			best = k
+		}
+	}
+	stay := map[int]bool{}
+	for k := best; k >= 0; k = prev[k] {
+		stay[k] = true
+	}
+	for k, p := range ps {
+		if !stay[k] {
+			mv[p.b] = true
+		}
+	}
+	return mv
+}
+
+// key returns the key that identifies the items of the lists at path, when
+// they are merged by key, or "".
+func (m merger) key(path []string) string {
+	if ls := m.strategy(path); ls.Merge == ListKey {
+		return ls.Key
+	}
+	return ""
+}
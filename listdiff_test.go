@@ -0,0 +1,158 @@
+package jam
+
+import (
+	"encoding/json"
+	"math"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+func TestLcs(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	list := func() []int {
+		s := make([]int, r.Intn(12))
+		for i := range s {
+			s[i] = r.Intn(4)
+		}
+		return s
+	}
+	for n := 0; n < 2000; n++ {
+		a, b := list(), list()
+		// tab[i][j] is the length of a longest common subsequence of a[i:], b[j:]
+		tab := make([][]int, len(a)+1)
+		for i := range tab {
+			tab[i] = make([]int, len(b)+1)
+		}
+		for i := len(a) - 1; i >= 0; i-- {
+			for j := len(b) - 1; j >= 0; j-- {
+				switch {
+				case a[i] == b[j]:
+					tab[i][j] = tab[i+1][j+1] + 1
+				case tab[i+1][j] >= tab[i][j+1]:
+					tab[i][j] = tab[i+1][j]
+				default:
+					tab[i][j] = tab[i][j+1]
+				}
+			}
+		}
+		sparse, _ := newDiffer(a, b).sparse(len(a) * len(b))
+		for _, ps := range [][]pair{sparse, newDiffer(a, b).myers()} {
+			if len(ps) != tab[0][0] {
+				t.Fatalf("%v %v: expected %d pairs, got %v", a, b, tab[0][0], ps)
+			}
+			for k, p := range ps {
+				if a[p.a] != b[p.b] || k > 0 && (p.a <= ps[k-1].a || p.b <= ps[k-1].b) {
+					t.Fatalf("%v %v: pairs are not a common subsequence, %v", a, b, ps)
+				}
+			}
+		}
+	}
+}
+
+func TestMoved(t *testing.T) {
+	// the fewest moves, and of those the least displacement, in quadratic time
+	slow := func(ps []pair) map[int]bool {
+		mv := map[int]bool{}
+		if len(ps) == 0 {
+			return mv
+		}
+		shift := func(p pair) int {
+			if p.a > p.b {
+				return p.a - p.b
+			}
+			return p.b - p.a
+		}
+		n, d, prev := make([]int, len(ps)), make([]int, len(ps)), make([]int, len(ps))
+		best := 0
+		for k, p := range ps {
+			n[k], d[k], prev[k] = 1, shift(p), -1
+			for q := 0; q < k; q++ {
+				if ps[q].a < p.a && (n[q]+1 > n[k] || n[q]+1 == n[k] && d[q]+shift(p) < d[k]) {
+					n[k], d[k], prev[k] = n[q]+1, d[q]+shift(p), q
+				}
+			}
+			if n[k] > n[best] || n[k] == n[best] && d[k] < d[best] {
+				best = k
+			}
+		}
+		stay := map[int]bool{}
+		for k := best; k >= 0; k = prev[k] {
+			stay[k] = true
+		}
+		for k, p := range ps {
+			if !stay[k] {
+				mv[p.b] = true
+			}
+		}
+		return mv
+	}
+	r := rand.New(rand.NewSource(1))
+	for n := 0; n < 2000; n++ {
+		ps := []pair{}
+		for j, i := range r.Perm(r.Intn(10)) {
+			if r.Intn(4) > 0 {
+				ps = append(ps, pair{i, j})
+			}
+		}
+		if x, v := slow(ps), moved(ps); !reflect.DeepEqual(x, v) {
+			t.Fatalf("%v: expected %v, got %v", ps, x, v)
+		}
+	}
+}
+
+func TestMatchLong(t *testing.T) {
+	a, b := make([]interface{}, 50000), make([]interface{}, 0, 50000)
+	for i := range a {
+		a[i] = float64(i)
+	}
+	for i, v := range a {
+		switch i % 1000 {
+		case 0:
+			b = append(b, "new")
+		case 1:
+		default:
+			b = append(b, v)
+		}
+	}
+	if ps := match(a, b, ""); len(ps) != 49950 {
+		t.Errorf("expected 49950 pairs, got %d", len(ps))
+	}
+	if cs := Changes(a, b); len(cs) != 100 {
+		t.Errorf("expected 100 changes, got %d", len(cs))
+	}
+
+	// shuffled, and shuffled with many equal items
+	r := rand.New(rand.NewSource(1))
+	for _, s := range []struct{ len, mod int }{{50000, 50000}, {5000, 100}} {
+		a, b := make([]interface{}, s.len), make([]interface{}, s.len)
+		for i := range a {
+			a[i] = float64(i % s.mod)
+		}
+		for i, j := range r.Perm(len(a)) {
+			b[i] = a[j]
+		}
+		if ps := match(a, b, ""); len(ps) != len(a) {
+			t.Errorf("expected %d pairs, got %d", len(a), len(ps))
+		}
+	}
+}
+
+func TestDigest(t *testing.T) {
+	m := NewMap()
+	m.Set("b", 2.0)
+	m.Set("a", _s{json.Number("1.0")})
+	var ss = [][]interface{}{
+		{1.0, 1, int64(1), json.Number("1"), json.Number("1.00"), json.Number("1e0")},
+		{0.0, math.Copysign(0, -1), json.Number("-0")},
+		{json.Number("123456789012345678901"), json.Number("1.23456789012345678901e20")},
+		{_m{"a": _s{1.0}, "b": json.Number("2")}, m},
+	}
+	for _, s := range ss {
+		for _, v := range s[1:] {
+			if !equal(s[0], v) || digest(s[0]) != digest(v) {
+				t.Errorf("%v %v: expected equal values with the same digest", s[0], v)
+			}
+		}
+	}
+}
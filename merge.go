@@ -0,0 +1,233 @@
+package jam
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Delete is a value that deletes its key from the map it is merged into. In
+// yaml it is written as a tag, "key: !delete". No other decoded value is
+// Delete, the string "!delete" deletes only with MergeOptions.DeleteStrings.
+const Delete = deletion("!delete")
+
+// deletion is the type of Delete, it is written as the string "!delete".
+type deletion string
+
+// ListMerge is a way to merge lists.
+type ListMerge int
+
+const (
+	// ListIndex merges items by index, items past the end are appended.
+	ListIndex ListMerge = iota
+	// ListReplace replaces the list.
+	ListReplace
+	// ListAppend appends the items.
+	ListAppend
+	// ListPrepend puts the items in front.
+	ListPrepend
+	// ListUnion appends the items and leaves out duplicates.
+	ListUnion
+	// ListKey merges map items with the same value of a key, other items
+	// are appended.
+	ListKey
+)
+
+// ListStrategy is a way to merge lists, Key is the key of ListKey.
+type ListStrategy struct {
+	Merge ListMerge
+	Key   string
+}
+
+// ParseListStrategy parses a list strategy: index, replace, append, prepend,
+// union, or key:name to merge items by their "name".
+func ParseListStrategy(s string) (ListStrategy, error) {
+	switch {
+	case s == "index":
+		return ListStrategy{Merge: ListIndex}, nil
+	case s == "replace":
+		return ListStrategy{Merge: ListReplace}, nil
+	case s == "append":
+		return ListStrategy{Merge: ListAppend}, nil
+	case s == "prepend":
+		return ListStrategy{Merge: ListPrepend}, nil
+	case s == "union":
+		return ListStrategy{Merge: ListUnion}, nil
+	case strings.HasPrefix(s, "key:") && len(s) > len("key:"):
+		return ListStrategy{Merge: ListKey, Key: s[len("key:"):]}, nil
+	}
+	return ListStrategy{}, fmt.Errorf("unknown list strategy %q", s)
+}
+
+// MergeOptions configure MergeWith. Lists is the strategy for lists, Paths
+// are strategies for the lists at paths, which take precedence. A path is
+// map keys separated by a dot, "*" matches any key. List items add nothing to
+// a path, "spec.containers.ports" are the ports of each container.
+//
+// When Patch is set a nil value deletes its key, as in a json merge patch
+// (RFC 7396). When DeleteStrings is set the string "!delete" deletes its key
+// like Delete, for formats without tags. When InPlace is set a is merged in
+// place and the result shares parts with a and b, which is faster.
+type MergeOptions struct {
+	Lists         ListStrategy
+	Paths         map[string]ListStrategy
+	Patch         bool
+	DeleteStrings bool
+	InPlace       bool
+}
+
+// MergeWith outputs the union of a and b with preference to b on matching
+// keys, like Merge, with lists merged as opts say.
+func MergeWith(a, b interface{}, opts MergeOptions) interface{} {
+	if !opts.InPlace {
+		a, b = Clone(a), Clone(b)
+	}
+	return merger(opts).merge(a, b, nil)
+}
+
+// MergeInPlace is Merge, merging into a in place. The result shares parts
+// with a and b, which is faster but neither may be used after.
+func MergeInPlace(a, b interface{}) interface{} {
+	return MergeWith(a, b, MergeOptions{InPlace: true})
+}
+
+// MergePatch applies json merge patch b to a (RFC 7396). A nil value deletes
+// its key and lists are replaced.
+func MergePatch(a, b interface{}) interface{} {
+	return MergeWith(a, b, MergeOptions{Lists: ListStrategy{Merge: ListReplace}, Patch: true})
+}
+
+// merger merges with options.
+type merger MergeOptions
+
+// merge merges b into a, path is the map keys to a. A map b is merged into
+// an empty map when a is not a map, which leaves out the keys b deletes.
+func (m merger) merge(a, b interface{}, path []string) interface{} {
+	if isMap(b) && !isMap(a) {
+		a = NewMap()
+		if !isOrdered(b) {
+			a = map[string]interface{}{}
+		}
+	}
+	if ma, mb, ok := maps(a, b); ok {
+		for _, k := range mb.ks {
+			v := mb.m[k]
+			if u := bare(v); u == Delete || m.Patch && u == nil || m.DeleteStrings && u == string(Delete) {
+				ma.Delete(k)
+				continue
+			}
+			u := ma.m[k]
+			ma.Set(k, m.merge(u, v, append(path[:len(path):len(path)], k)))
+		}
+		if isOrdered(a) || isOrdered(b) {
+			return ma
+		}
+		return ma.m
+	}
+	if reflect.TypeOf(a) != reflect.TypeOf(b) {
+		return b
+	}
+	switch b := b.(type) {
+	case []interface{}:
+		return m.list(a.([]interface{}), b, path)
+	default:
+		return b
+	}
+}
+
+// list merges list b into list a at path.
+func (m merger) list(a, b []interface{}, path []string) []interface{} {
+	ls := m.strategy(path)
+	switch ls.Merge {
+	case ListReplace:
+		return b
+	case ListAppend:
+		return append(a, b...)
+	case ListPrepend:
+		return append(append([]interface{}{}, b...), a...)
+	case ListUnion:
+		o := []interface{}{}
+		for _, v := range append(a, b...) {
+			if !contains(o, v) {
+				o = append(o, v)
+			}
+		}
+		return o
+	case ListKey:
+		for _, v := range b {
+			i := keyed(a, ls.Key, v)
+			if i < 0 {
+				a = append(a, v)
+				continue
+			}
+			a[i] = m.merge(a[i], v, path)
+		}
+		return a
+	}
+	for k, v := range b {
+		if k < len(a) {
+			a[k] = m.merge(a[k], v, path)
+			continue
+		}
+		a = append(a, v)
+	}
+	return a
+}
+
+// strategy returns the list strategy at path. Of the paths that match, the
+// one with the fewest "*" wins.
+func (m merger) strategy(path []string) ListStrategy {
+	ls, best, stars := m.Lists, "", -1
+	for p, u := range m.Paths {
+		n := strings.Count(p, "*")
+		if !matches(strings.Split(p, "."), path) || stars >= 0 && (n > stars || n == stars && p > best) {
+			continue
+		}
+		ls, best, stars = u, p, n
+	}
+	return ls
+}
+
+// matches reports whether path matches the pattern, "*" matches any key.
+func matches(pattern, path []string) bool {
+	if len(pattern) != len(path) {
+		return false
+	}
+	for i, p := range pattern {
+		if p != "*" && p != path[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// contains reports whether v is equal to an item of s.
+func contains(s []interface{}, v interface{}) bool {
+	for _, u := range s {
+		if equal(u, v) {
+			return true
+		}
+	}
+	return false
+}
+
+// keyed returns the index of the map in s with the same value of key k as
+// map v, or -1.
+func keyed(s []interface{}, k string, v interface{}) int {
+	mv, ok := toMap(v)
+	if !ok {
+		return -1
+	}
+	x, ok := mv.Get(k)
+	if !ok {
+		return -1
+	}
+	for i, u := range s {
+		if mu, ok := toMap(u); ok {
+			if y, ok := mu.Get(k); ok && equal(x, y) {
+				return i
+			}
+		}
+	}
+	return -1
+}
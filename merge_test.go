@@ -0,0 +1,307 @@
+package jam
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMergeWith(t *testing.T) {
+	a := func() interface{} {
+		return _m{
+			"l": _s{1.0, 2.0},
+			"c": _s{_m{"name": "a", "image": "x"}, _m{"name": "b", "image": "y"}},
+		}
+	}
+	b := _m{
+		"l": _s{2.0, 3.0},
+		"c": _s{_m{"name": "b", "image": "z"}, _m{"name": "c", "image": "w"}},
+	}
+	var ss = []struct {
+		name string
+		o    MergeOptions
+		x    interface{}
+	}{
+		{
+			"index",
+			MergeOptions{},
+			_m{
+				"l": _s{2.0, 3.0},
+				"c": _s{_m{"name": "b", "image": "z"}, _m{"name": "c", "image": "w"}},
+			},
+		},
+		{
+			"replace",
+			MergeOptions{Lists: ListStrategy{Merge: ListReplace}},
+			b,
+		},
+		{
+			"append",
+			MergeOptions{Lists: ListStrategy{Merge: ListAppend}},
+			_m{
+				"l": _s{1.0, 2.0, 2.0, 3.0},
+				"c": _s{
+					_m{"name": "a", "image": "x"}, _m{"name": "b", "image": "y"},
+					_m{"name": "b", "image": "z"}, _m{"name": "c", "image": "w"},
+				},
+			},
+		},
+		{
+			"prepend",
+			MergeOptions{Lists: ListStrategy{Merge: ListPrepend}},
+			_m{
+				"l": _s{2.0, 3.0, 1.0, 2.0},
+				"c": _s{
+					_m{"name": "b", "image": "z"}, _m{"name": "c", "image": "w"},
+					_m{"name": "a", "image": "x"}, _m{"name": "b", "image": "y"},
+				},
+			},
+		},
+		{
+			"union",
+			MergeOptions{Lists: ListStrategy{Merge: ListUnion}},
+			_m{
+				"l": _s{1.0, 2.0, 3.0},
+				"c": _s{
+					_m{"name": "a", "image": "x"}, _m{"name": "b", "image": "y"},
+					_m{"name": "b", "image": "z"}, _m{"name": "c", "image": "w"},
+				},
+			},
+		},
+		{
+			"key by path",
+			MergeOptions{
+				Lists: ListStrategy{Merge: ListUnion},
+				Paths: map[string]ListStrategy{"c": {Merge: ListKey, Key: "name"}},
+			},
+			_m{
+				"l": _s{1.0, 2.0, 3.0},
+				"c": _s{
+					_m{"name": "a", "image": "x"}, _m{"name": "b", "image": "z"},
+					_m{"name": "c", "image": "w"},
+				},
+			},
+		},
+		{
+			"star path",
+			MergeOptions{Paths: map[string]ListStrategy{"*": {Merge: ListAppend}, "l": {Merge: ListReplace}}},
+			_m{
+				"l": _s{2.0, 3.0},
+				"c": _s{
+					_m{"name": "a", "image": "x"}, _m{"name": "b", "image": "y"},
+					_m{"name": "b", "image": "z"}, _m{"name": "c", "image": "w"},
+				},
+			},
+		},
+	}
+	for _, s := range ss {
+		if v := MergeWith(a(), b, s.o); !reflect.DeepEqual(v, s.x) {
+			t.Errorf("%s: expected %v, got %v", s.name, s.x, v)
+		}
+	}
+}
+
+func TestMergeWithNested(t *testing.T) {
+	a := _m{"c": _s{_m{"name": "a", "ports": _s{1.0}}}}
+	b := _m{"c": _s{_m{"name": "a", "ports": _s{2.0}}}}
+	o := MergeOptions{Paths: map[string]ListStrategy{
+		"c":       {Merge: ListKey, Key: "name"},
+		"c.ports": {Merge: ListAppend},
+	}}
+	x := _m{"c": _s{_m{"name": "a", "ports": _s{1.0, 2.0}}}}
+	if v := MergeWith(a, b, o); !reflect.DeepEqual(v, x) {
+		t.Errorf("expected %v, got %v", x, v)
+	}
+}
+
+func TestParseListStrategy(t *testing.T) {
+	var ss = []struct {
+		i string
+		x ListStrategy
+	}{
+		{"index", ListStrategy{Merge: ListIndex}},
+		{"replace", ListStrategy{Merge: ListReplace}},
+		{"append", ListStrategy{Merge: ListAppend}},
+		{"prepend", ListStrategy{Merge: ListPrepend}},
+		{"union", ListStrategy{Merge: ListUnion}},
+		{"key:name", ListStrategy{Merge: ListKey, Key: "name"}},
+	}
+	for _, s := range ss {
+		ls, err := ParseListStrategy(s.i)
+		if err != nil || ls != s.x {
+			t.Errorf("%s: expected %v, got %v %v", s.i, s.x, ls, err)
+		}
+	}
+	for _, s := range []string{"", "key:", "nope"} {
+		if _, err := ParseListStrategy(s); err == nil {
+			t.Errorf("%q: expected an error", s)
+		}
+	}
+}
+
+func TestMergeDelete(t *testing.T) {
+	var ss = []struct {
+		a, b, x interface{}
+	}{
+		{_m{"a": 1.0, "b": 2.0}, _m{"a": Delete}, _m{"b": 2.0}},
+		{_m{"a": _m{"b": 1.0, "c": 2.0}}, _m{"a": _m{"b": Delete}}, _m{"a": _m{"c": 2.0}}},
+		{_m{"a": 1.0}, _m{"b": _m{"c": Delete, "d": 1.0}}, _m{"a": 1.0, "b": _m{"d": 1.0}}},
+		{_m{"a": 1.0}, _m{"a": nil}, _m{"a": nil}},
+		{om("b", 1.0, "a", 2.0), om("b", Delete, "c", 3.0), om("a", 2.0, "c", 3.0)},
+		{_m{"a": 1.0, "b": 2.0}, _m{"a": "!delete"}, _m{"a": "!delete", "b": 2.0}},
+	}
+	for _, s := range ss {
+		if v := Merge(s.a, s.b); !reflect.DeepEqual(v, s.x) {
+			t.Errorf("expected %v, got %v", s.x, v)
+		}
+	}
+	opts := MergeOptions{DeleteStrings: true}
+	if v, x := MergeWith(_m{"a": 1.0, "b": 2.0}, _m{"a": "!delete", "b": Delete}, opts), (_m{}); !reflect.DeepEqual(v, x) {
+		t.Errorf("expected %v, got %v", x, v)
+	}
+}
+
+func TestMergeDeleteDecode(t *testing.T) {
+	var v interface{}
+	d := NewDecoder(
+		strings.NewReader("a: 1\nb:\n  c: 2\n  d: 3\n"),
+		strings.NewReader("a: !delete\nb:\n  c: \"!delete\"\n"),
+		strings.NewReader(`{"b":{"d":"!delete"}}`),
+	)
+	if err := d.Decode(&v); err != nil {
+		t.Fatal(err)
+	}
+	if x := (_m{"b": _m{"c": "!delete", "d": "!delete"}}); !reflect.DeepEqual(v, x) {
+		t.Errorf("expected %v, got %v", x, v)
+	}
+}
+
+func TestMergePatch(t *testing.T) {
+	// the examples of RFC 7396, appendix A
+	var ss = []struct {
+		a, b, x string
+	}{
+		{`{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{`{"a":"b"}`, `{"a":null}`, `{}`},
+		{`{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+		{`{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"c"}`, `{"a":["b"]}`, `{"a":["b"]}`},
+		{`{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+		{`{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+		{`["a","b"]`, `["c","d"]`, `["c","d"]`},
+		{`{"a":"b"}`, `["c"]`, `["c"]`},
+		{`{"a":"foo"}`, `null`, `null`},
+		{`{"a":"foo"}`, `"bar"`, `"bar"`},
+		{`{"e":null}`, `{"a":1}`, `{"a":1,"e":null}`},
+		{`[1,2]`, `{"a":"b","c":null}`, `{"a":"b"}`},
+		{`{}`, `{"a":{"bb":{"ccc":null}}}`, `{"a":{"bb":{}}}`},
+	}
+	for _, s := range ss {
+		var a, b interface{}
+		if err := json.Unmarshal([]byte(s.a), &a); err != nil {
+			t.Fatal(err)
+		}
+		if err := json.Unmarshal([]byte(s.b), &b); err != nil {
+			t.Fatal(err)
+		}
+		o, err := json.Marshal(MergePatch(a, b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(o) != s.x {
+			t.Errorf("%s + %s: expected %s, got %s", s.a, s.b, s.x, o)
+		}
+	}
+}
+
+func TestMergeUntouched(t *testing.T) {
+	a := func() interface{} {
+		return _m{"l": _s{1.0, _m{"x": 1.0}}, "m": om("b", 1.0, "a", _s{2.0}), "d": 1.0, "bin": []byte("a")}
+	}
+	b := func() interface{} {
+		return _m{"l": _s{_m{"y": 2.0}}, "m": om("a", _s{3.0}, "c", 4.0), "d": Delete, "n": _m{"z": 5.0}}
+	}
+	var ss = []struct {
+		name string
+		fn   func(a, b interface{}) interface{}
+	}{
+		{"merge", Merge},
+		{"merge patch", MergePatch},
+		{"merge append", func(a, b interface{}) interface{} {
+			return MergeWith(a, b, MergeOptions{Lists: ListStrategy{Merge: ListAppend}})
+		}},
+		{"merge key", func(a, b interface{}) interface{} {
+			return MergeWith(a, b, MergeOptions{Lists: ListStrategy{Merge: ListKey, Key: "x"}})
+		}},
+		{"diff", Diff},
+		{"jam merge", func(a, b interface{}) interface{} {
+			j := NewJam(a)
+			j.Merge(b)
+			return j.Value(0)
+		}},
+		{"jam diff", func(a, b interface{}) interface{} {
+			j := NewJam(a)
+			j.Diff(b)
+			return j.Value(0)
+		}},
+	}
+	for _, s := range ss {
+		va, vb := a(), b()
+		o := s.fn(va, vb)
+		if !reflect.DeepEqual(va, a()) || !reflect.DeepEqual(vb, b()) {
+			t.Errorf("%s: inputs changed", s.name)
+		}
+		// the result shares nothing with the inputs
+		scribble(o)
+		if !reflect.DeepEqual(va, a()) || !reflect.DeepEqual(vb, b()) {
+			t.Errorf("%s: inputs share parts with the result", s.name)
+		}
+	}
+}
+
+// scribble changes every map, list and binary value in the tree of v.
+func scribble(v interface{}) {
+	switch v := v.(type) {
+	case *Map:
+		for _, k := range v.Keys() {
+			scribble(v.m[k])
+		}
+		v.Set("scribble", true)
+	case map[string]interface{}:
+		for _, u := range v {
+			scribble(u)
+		}
+		v["scribble"] = true
+	case []interface{}:
+		for i, u := range v {
+			scribble(u)
+			v[i] = "scribble"
+		}
+	case []byte:
+		for i := range v {
+			v[i] = '!'
+		}
+	}
+}
+
+func TestMergeInPlace(t *testing.T) {
+	a := _m{"a": 1.0}
+	MergeInPlace(a, _m{"b": 2.0})
+	if x := (_m{"a": 1.0, "b": 2.0}); !reflect.DeepEqual(a, x) {
+		t.Errorf("expected %v, got %v", x, a)
+	}
+}
+
+func TestClone(t *testing.T) {
+	v := _m{"a": _s{om("b", []byte("c"), "d", 1.0)}, "e": "f"}
+	c := Clone(v)
+	if !reflect.DeepEqual(c, v) {
+		t.Errorf("expected %v, got %v", v, c)
+	}
+	scribble(c)
+	if x := (_m{"a": _s{om("b", []byte("c"), "d", 1.0)}, "e": "f"}); !reflect.DeepEqual(v, x) {
+		t.Errorf("clone shares parts with the original")
+	}
+}
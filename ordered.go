@@ -0,0 +1,331 @@
+package jam
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// Map is a map that keeps its keys in the order they are first set. A
+// Decoder set to KeepOrder decodes maps as a *Map, in source order. Merge,
+// Diff and the filters keep the order and add new keys at the end, and the
+// encoders write the keys in order. A map[string]interface{} is taken to be
+// in sorted order wherever a *Map is.
+type Map struct {
+	ks []string
+	m  map[string]interface{}
+}
+
+// NewMap creates an empty Map.
+func NewMap() *Map {
+	return &Map{m: map[string]interface{}{}}
+}
+
+// Len returns the number of keys.
+func (m *Map) Len() int {
+	return len(m.ks)
+}
+
+// Keys returns the keys in order.
+func (m *Map) Keys() []string {
+	return append([]string{}, m.ks...)
+}
+
+// Get returns the value of key k, and whether it is set.
+func (m *Map) Get(k string) (interface{}, bool) {
+	v, ok := m.m[k]
+	return v, ok
+}
+
+// Set sets key k to v. A new key is added at the end.
+func (m *Map) Set(k string, v interface{}) {
+	if m.m == nil {
+		m.m = map[string]interface{}{}
+	}
+	if _, ok := m.m[k]; !ok {
+		m.ks = append(m.ks, k)
+	}
+	m.m[k] = v
+}
+
+// Delete removes key k.
+func (m *Map) Delete(k string) {
+	if _, ok := m.m[k]; !ok {
+		return
+	}
+	delete(m.m, k)
+	for i, u := range m.ks {
+		if u == k {
+			m.ks = append(m.ks[:i:i], m.ks[i+1:]...)
+			break
+		}
+	}
+}
+
+// MarshalJSON writes the map as a json object with its keys in order.
+func (m *Map) MarshalJSON() ([]byte, error) {
+	var bb bytes.Buffer
+	bb.WriteByte('{')
+	for i, k := range m.ks {
+		if i > 0 {
+			bb.WriteByte(',')
+		}
+		b, err := json.Marshal(k)
+		if err != nil {
+			return nil, err
+		}
+		bb.Write(b)
+		bb.WriteByte(':')
+		if b, err = json.Marshal(m.m[k]); err != nil {
+			return nil, err
+		}
+		bb.Write(b)
+	}
+	bb.WriteByte('}')
+	return bb.Bytes(), nil
+}
+
+// toMap returns v as a *Map when it is a map. A map[string]interface{} is
+// wrapped with its keys sorted, setting keys of the result sets them in v.
+func toMap(v interface{}) (*Map, bool) {
+	switch v := v.(type) {
+	case *Map:
+		return v, true
+	case map[string]interface{}:
+		ks := make([]string, 0, len(v))
+		for k := range v {
+			ks = append(ks, k)
+		}
+		sort.Strings(ks)
+		return &Map{ks: ks, m: v}, true
+	}
+	return nil, false
+}
+
+// maps returns a and b as a *Map when both are maps, see toMap.
+func maps(a, b interface{}) (*Map, *Map, bool) {
+	ma, ok := toMap(a)
+	if !ok {
+		return nil, nil, false
+	}
+	mb, ok := toMap(b)
+	return ma, mb, ok
+}
+
+// isMap reports whether v is a map, either kind.
+func isMap(v interface{}) bool {
+	_, ok := toMap(v)
+	return ok
+}
+
+// isOrdered reports whether v is a *Map.
+func isOrdered(v interface{}) bool {
+	_, ok := v.(*Map)
+	return ok
+}
+
+// plain returns v with each *Map replaced by a map[string]interface{}.
+func plain(v interface{}) interface{} {
+	switch v := v.(type) {
+	case *Map:
+		m := make(map[string]interface{}, len(v.ks))
+		for _, k := range v.ks {
+			m[k] = plain(v.m[k])
+		}
+		return m
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, u := range v {
+			m[k] = plain(u)
+		}
+		return m
+	case []interface{}:
+		s := make([]interface{}, len(v))
+		for i, u := range v {
+			s[i] = plain(u)
+		}
+		return s
+	}
+	return v
+}
+
+// decodeJson decodes the next json value from jd, objects are a *Map.
+func decodeJson(jd *json.Decoder) (interface{}, error) {
+	v, _, err := decodeJsonAt(jd, nil)
+	return v, err
+}
+
+// decodeJsonAt decodes the next json value from jd like decodeJson. When fn
+// is not nil it also returns the origin tree of the value, the origin of a
+// leaf is fn of the input offset of its end.
+func decodeJsonAt(jd *json.Decoder, fn func(int64) Origin) (interface{}, interface{}, error) {
+	t, err := jd.Token()
+	if err == io.EOF {
+		return nil, nil, err
+	}
+	if err != nil {
+		return nil, nil, unexpected(err)
+	}
+	switch t {
+	case json.Delim('{'):
+		m, o := NewMap(), map[string]interface{}{}
+		for jd.More() {
+			k, err := jd.Token()
+			if err != nil {
+				return nil, nil, unexpected(err)
+			}
+			v, ov, err := decodeJsonAt(jd, fn)
+			if err != nil {
+				return nil, nil, unexpected(err)
+			}
+			m.Set(k.(string), v)
+			if fn != nil {
+				o[k.(string)] = ov
+			}
+		}
+		if _, err := jd.Token(); err != nil {
+			return nil, nil, unexpected(err)
+		}
+		return m, o, nil
+	case json.Delim('['):
+		s, o := []interface{}{}, []interface{}{}
+		for jd.More() {
+			v, ov, err := decodeJsonAt(jd, fn)
+			if err != nil {
+				return nil, nil, unexpected(err)
+			}
+			s = append(s, v)
+			if fn != nil {
+				o = append(o, ov)
+			}
+		}
+		if _, err := jd.Token(); err != nil {
+			return nil, nil, unexpected(err)
+		}
+		return s, o, nil
+	}
+	if fn == nil {
+		return t, nil, nil
+	}
+	return t, fn(jd.InputOffset()), nil
+}
+
+// unexpected returns an eofErr for io.EOF, io.ErrUnexpectedEOF and the
+// syntax error of input that ends after a token, the end of input inside a
+// value.
+func unexpected(err error) error {
+	var se *json.SyntaxError
+	if err == io.EOF || err == io.ErrUnexpectedEOF || errors.As(err, &se) && se.Error() == "unexpected end of JSON input" {
+		return &eofErr{}
+	}
+	return err
+}
+
+// eofErr is the end of json input inside a value.
+type eofErr struct{}
+
+func (e *eofErr) Error() string { return io.ErrUnexpectedEOF.Error() }
+func (e *eofErr) Unwrap() error { return io.ErrUnexpectedEOF }
+
+// orderToml returns toml value v with its maps as a *Map, keys in the order
+// they are in the source according to md.
+func orderToml(v interface{}, md toml.MetaData) interface{} {
+	pos := map[string]int{}
+	for i, k := range md.Keys() {
+		p := strings.Join(k, "\x00")
+		if _, ok := pos[p]; !ok {
+			pos[p] = i
+		}
+	}
+	return orderMaps(v, "", pos)
+}
+
+// orderMaps returns v with its maps as a *Map, keys ordered by the position
+// of their path in pos. Keys without a position come last.
+func orderMaps(v interface{}, path string, pos map[string]int) interface{} {
+	join := func(k string) string {
+		if path == "" {
+			return k
+		}
+		return path + "\x00" + k
+	}
+	switch v := v.(type) {
+	case map[string]interface{}:
+		m, _ := toMap(v)
+		ks := m.Keys()
+		sort.SliceStable(ks, func(i, j int) bool {
+			pi, iok := pos[join(ks[i])]
+			pj, jok := pos[join(ks[j])]
+			if iok && jok {
+				return pi < pj
+			}
+			return iok && !jok
+		})
+		o := &Map{ks: ks, m: make(map[string]interface{}, len(ks))}
+		for _, k := range ks {
+			o.m[k] = orderMaps(v[k], join(k), pos)
+		}
+		return o
+	case []map[string]interface{}:
+		s := make([]interface{}, len(v))
+		for i, u := range v {
+			s[i] = orderMaps(u, path, pos)
+		}
+		return s
+	case []interface{}:
+		s := make([]interface{}, len(v))
+		for i, u := range v {
+			s[i] = orderMaps(u, path, pos)
+		}
+		return s
+	}
+	return v
+}
+
+// tomlOrder returns v with each *Map replaced by a struct with a field for
+// each key, in order, which toml writes in order. A map with a key that can
+// not be a struct tag is left a map, in sorted order.
+func tomlOrder(v interface{}) interface{} {
+	switch v := v.(type) {
+	case *Map:
+		fs := make([]reflect.StructField, len(v.ks))
+		vs := make([]reflect.Value, len(v.ks))
+		for i, k := range v.ks {
+			if k == "-" || strings.Contains(k, ",") {
+				return plain(v)
+			}
+			u := tomlOrder(v.m[k])
+			t := reflect.TypeOf(u)
+			if t == nil {
+				t = reflect.TypeOf((*interface{})(nil)).Elem()
+			}
+			fs[i] = reflect.StructField{
+				Name: "F" + strconv.Itoa(i),
+				Type: t,
+				Tag:  reflect.StructTag("toml:" + strconv.Quote(k)),
+			}
+			vs[i] = reflect.ValueOf(u)
+		}
+		s := reflect.New(reflect.StructOf(fs)).Elem()
+		for i, u := range vs {
+			if u.IsValid() {
+				s.Field(i).Set(u)
+			}
+		}
+		return s.Interface()
+	case []interface{}:
+		s := make([]interface{}, len(v))
+		for i, u := range v {
+			s[i] = tomlOrder(u)
+		}
+		return s
+	}
+	return v
+}
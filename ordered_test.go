@@ -0,0 +1,128 @@
+package jam
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// om makes a *Map of keys and values in turn.
+func om(kvs ...interface{}) *Map {
+	m := NewMap()
+	for i := 0; i+1 < len(kvs); i += 2 {
+		m.Set(kvs[i].(string), kvs[i+1])
+	}
+	return m
+}
+
+func TestMap(t *testing.T) {
+	m := om("b", 1, "a", 2, "c", 3)
+	m.Set("a", 4)
+	m.Delete("b")
+	m.Delete("x")
+	m.Set("b", 5)
+	if x := []string{"a", "c", "b"}; !reflect.DeepEqual(m.Keys(), x) {
+		t.Errorf("expected %v, got %v", x, m.Keys())
+	}
+	if v, ok := m.Get("a"); !ok || v != 4 {
+		t.Errorf("expected 4, got %v", v)
+	}
+	b, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if x := `{"a":4,"c":3,"b":5}`; string(b) != x {
+		t.Errorf("expected %s, got %s", x, b)
+	}
+}
+
+func TestKeepOrder(t *testing.T) {
+	var ss = []struct {
+		d *Decoder
+		x string
+	}{
+		{NewDecoder(strings.NewReader(`{"b":1,"a":{"d":[{"f":1,"e":2}],"c":3}}`)), `{"b":1,"a":{"d":[{"f":1,"e":2}],"c":3}}`},
+		{NewDecoder(strings.NewReader("b: 1\na:\n  d: 1\n  c: 2\n")), `{"b":1,"a":{"d":1,"c":2}}`},
+		{NewDecoder(strings.NewReader("x: &x {d: 1, c: 2}\ny:\n  b: 1\n  <<: *x\n  a: 2\n")), `{"x":{"d":1,"c":2},"y":{"b":1,"d":1,"c":2,"a":2}}`},
+		{NewDecoder(strings.NewReader("b = 1\n[t]\nd = 1\nc = 2\n[[l]]\nf = 1\ne = 2\n")), `{"b":1,"t":{"d":1,"c":2},"l":[{"f":1,"e":2}]}`},
+		{NewDecoder(strings.NewReader(`<r b="1" a="2"><d>1</d><c>2</c></r>`)), `{"r":{"@b":"1","@a":"2","d":"1","c":"2"}}`},
+		{NewDecoder(strings.NewReader("b,a\n1,2\n")).AsCsv(true), `[{"b":1,"a":2}]`},
+		{NewDecoder(strings.NewReader(`{"b":1}` + "\n" + `{"a":2}`)).AsJsonLines(), `{"b":1}`},
+	}
+	for _, s := range ss {
+		var v interface{}
+		if err := s.d.UseNumber().KeepOrder().Decode(&v); err != nil {
+			t.Errorf("%s: %s", s.x, err)
+			continue
+		}
+		var bb bytes.Buffer
+		if err := NewEncoder(&bb).AsJson().Encode(v); err != nil {
+			t.Fatal(err)
+		}
+		if x := s.x + "\n"; bb.String() != x {
+			t.Errorf("expected %s, got %s", x, bb.String())
+		}
+	}
+}
+
+func TestKeepOrderOff(t *testing.T) {
+	var v interface{}
+	if err := NewDecoder(strings.NewReader(`{"b":1,"a":{"c":[{"d":2}]}}`)).Decode(&v); err != nil {
+		t.Fatal(err)
+	}
+	if x := (_m{"b": 1.0, "a": _m{"c": _s{_m{"d": 2.0}}}}); !reflect.DeepEqual(v, x) {
+		t.Errorf("expected %#v, got %#v", x, v)
+	}
+}
+
+func TestOrderedOps(t *testing.T) {
+	var ss = []struct {
+		name string
+		v    interface{}
+		x    string
+	}{
+		{"merge", Merge(om("b", 1, "a", om("d", 1)), om("c", 2, "a", om("e", 2), "b", 3)), `{"b":3,"a":{"d":1,"e":2},"c":2}`},
+		{"merge plain", Merge(_m{"b": 1, "a": 2}, om("d", 3, "c", 4)), `{"a":2,"b":1,"d":3,"c":4}`},
+		{"diff", Diff(om("b", 1, "a", 2), om("d", 3, "a", 2, "c", 4)), `{"d":3,"c":4}`},
+		{"filter", Filter(om("b", om("d", 1, "c", 2), "a", 3), "b"), `{"b":{"d":1,"c":2}}`},
+		{"filter inverted", FilterI(om("c", 1, "b", 2, "a", 3), "b"), `{"c":1,"a":3}`},
+	}
+	for _, s := range ss {
+		b, err := json.Marshal(s.v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != s.x {
+			t.Errorf("%s: expected %s, got %s", s.name, s.x, b)
+		}
+	}
+	if !equal(om("a", 1, "b", 2), _m{"b": 2.0, "a": json.Number("1")}) {
+		t.Errorf("expected maps in any order to be equal")
+	}
+}
+
+func TestOrderedEncode(t *testing.T) {
+	v := om("z", 1, "t", om("y", 2, "x", 3), "a", "s")
+	var ss = []struct {
+		e func(*Encoder) *Encoder
+		x string
+	}{
+		{(*Encoder).AsYaml, "---\nz: 1\nt:\n  \"y\": 2\n  x: 3\na: s\n"},
+		{(*Encoder).AsToml, "z = 1\na = \"s\"\n\n[t]\n  y = 2\n  x = 3\n"},
+		{(*Encoder).AsCsv, "z,t.y,t.x,a\n1,2,3,s\n"},
+		{(*Encoder).AsXml, "<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n<jam>\n  <z>1</z>\n  <t>\n    <y>2</y>\n    <x>3</x>\n  </t>\n  <a>s</a>\n</jam>\n"},
+		{(*Encoder).AsGo, "map[string]interface{}{\n\t\"a\": \"s\",\n\t\"t\": map[string]interface{}{\n\t\t\"x\": 3,\n\t\t\"y\": 2,\n\t},\n\t\"z\": 1,\n}\n"},
+	}
+	for _, s := range ss {
+		var bb bytes.Buffer
+		if err := s.e(NewEncoder(&bb)).Encode(v); err != nil {
+			t.Error(err)
+			continue
+		}
+		if bb.String() != s.x {
+			t.Errorf("expected\n%s\ngot\n%s", s.x, bb.String())
+		}
+	}
+}
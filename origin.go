@@ -0,0 +1,303 @@
+package jam
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	yaml3 "gopkg.in/yaml.v3"
+)
+
+// Origin is where a value was set: the Source it was decoded from, a file
+// name or the index of a reader, and the Line, counting from 1, or 0 when
+// the line is not known. Lines are known for yaml and json.
+type Origin struct {
+	Source string
+	Line   int
+}
+
+// String returns the origin as source:line, or the source alone.
+func (o Origin) String() string {
+	if o.Line == 0 {
+		return o.Source
+	}
+	return o.Source + ":" + strconv.Itoa(o.Line)
+}
+
+// traced is a leaf with its origin, merges that track origins merge trees
+// of traced leaves.
+type traced struct {
+	v interface{}
+	o Origin
+}
+
+// bare returns the leaf of v when it is traced, or v.
+func bare(v interface{}) interface{} {
+	if t, ok := v.(*traced); ok {
+		return t.v
+	}
+	return v
+}
+
+// trace returns v with each leaf traced, its origin the one at the same
+// place in origin tree o, or the zero Origin.
+func trace(v, o interface{}) interface{} {
+	om, _ := o.(map[string]interface{})
+	os, _ := o.([]interface{})
+	switch v := v.(type) {
+	case *Map:
+		m := &Map{ks: v.Keys(), m: make(map[string]interface{}, len(v.ks))}
+		for _, k := range v.ks {
+			m.m[k] = trace(v.m[k], om[k])
+		}
+		return m
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, u := range v {
+			m[k] = trace(u, om[k])
+		}
+		return m
+	case []interface{}:
+		s := make([]interface{}, len(v))
+		for i, u := range v {
+			var ou interface{}
+			if i < len(os) {
+				ou = os[i]
+			}
+			s[i] = trace(u, ou)
+		}
+		return s
+	}
+	ov, _ := o.(Origin)
+	return &traced{v, ov}
+}
+
+// untrace returns the value and the origin tree of traced tree v.
+func untrace(v interface{}) (interface{}, interface{}) {
+	switch v := v.(type) {
+	case *Map:
+		m, o := &Map{ks: v.Keys(), m: make(map[string]interface{}, len(v.ks))}, map[string]interface{}{}
+		for _, k := range v.ks {
+			m.m[k], o[k] = untrace(v.m[k])
+		}
+		return m, o
+	case map[string]interface{}:
+		m, o := make(map[string]interface{}, len(v)), map[string]interface{}{}
+		for k, u := range v {
+			m[k], o[k] = untrace(u)
+		}
+		return m, o
+	case []interface{}:
+		s, o := make([]interface{}, len(v)), make([]interface{}, len(v))
+		for i, u := range v {
+			s[i], o[i] = untrace(u)
+		}
+		return s, o
+	case *traced:
+		return v.v, v.o
+	}
+	return v, Origin{}
+}
+
+// origins returns the origin tree of v, every leaf of which is from o.
+func origins(v interface{}, o Origin) interface{} {
+	_, ot := untrace(trace(v, o))
+	return leaves(ot, func(interface{}) interface{} { return o })
+}
+
+// mergeTraced merges b into a like MergeWith, and the origin tree ob into
+// oa, each leaf keeps the origin of the value that set it.
+func mergeTraced(a, b, oa, ob interface{}, opts MergeOptions) (interface{}, interface{}) {
+	opts.InPlace = true
+	return untrace(MergeWith(trace(a, oa), trace(b, ob), opts))
+}
+
+// originAt returns the origin of the leaf at json pointer p in origin tree o.
+func originAt(o interface{}, p string) (Origin, bool) {
+	ts, err := pointer(p)
+	if err != nil {
+		return Origin{}, false
+	}
+	for _, t := range ts {
+		switch u := o.(type) {
+		case map[string]interface{}:
+			o = u[t]
+		case []interface{}:
+			i, err := index(t, len(u)-1)
+			if err != nil {
+				return Origin{}, false
+			}
+			o = u[i]
+		default:
+			return Origin{}, false
+		}
+	}
+	ov, ok := o.(Origin)
+	return ov, ok && ov != Origin{}
+}
+
+// yamlOrigins returns the origin tree of v, the value of node n, from source
+// s with line lines before it.
+func yamlOrigins(n *yaml3.Node, v interface{}, s string, line int) interface{} {
+	for n.Kind == yaml3.DocumentNode && len(n.Content) > 0 || n.Kind == yaml3.AliasNode {
+		if n.Kind == yaml3.AliasNode {
+			n = n.Alias
+			continue
+		}
+		n = n.Content[0]
+	}
+	at := Origin{s, n.Line + line}
+	if m, ok := toMap(v); ok {
+		o := map[string]interface{}{}
+		for _, k := range m.ks {
+			if c := yamlValue(n, k); c != nil {
+				o[k] = yamlOrigins(c, m.m[k], s, line)
+				continue
+			}
+			o[k] = origins(m.m[k], at)
+		}
+		return o
+	}
+	if l, ok := v.([]interface{}); ok {
+		o := make([]interface{}, len(l))
+		for i, u := range l {
+			if n.Kind == yaml3.SequenceNode && i < len(n.Content) {
+				o[i] = yamlOrigins(n.Content[i], u, s, line)
+				continue
+			}
+			o[i] = origins(u, at)
+		}
+		return o
+	}
+	return at
+}
+
+// yamlValue returns the value node of key k of mapping node n, its own or
+// by way of a merge key, or nil.
+func yamlValue(n *yaml3.Node, k string) *yaml3.Node {
+	if n.Kind != yaml3.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if c := n.Content[i]; !isMerge(c) && c.Kind == yaml3.ScalarNode && c.Value == k {
+			return n.Content[i+1]
+		}
+	}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if !isMerge(n.Content[i]) {
+			continue
+		}
+		v := n.Content[i+1]
+		for v.Kind == yaml3.AliasNode {
+			v = v.Alias
+		}
+		ms := []*yaml3.Node{v}
+		if v.Kind == yaml3.SequenceNode {
+			ms = v.Content
+		}
+		for _, m := range ms {
+			for m.Kind == yaml3.AliasNode {
+				m = m.Alias
+			}
+			if c := yamlValue(m, k); c != nil {
+				return c
+			}
+		}
+	}
+	return nil
+}
+
+// Annotated returns a copy of the document with a line comment on each value
+// with its origin, for a Doc with origins, see Decoder.TrackOrigins. The
+// origin follows a line comment that is there. A flow mapping or sequence has
+// one comment with the origins in it. Values from toml, xml and csv have no
+// line, their comment is the source alone.
+func (d *Doc) Annotated() *Doc {
+	u := *d
+	if d.n == nil {
+		u.n = &yaml3.Node{Kind: yaml3.DocumentNode, Content: []*yaml3.Node{yamlNode(d.v)}}
+		u.indent = 2
+	} else {
+		u.n = copyNode(d.n, map[*yaml3.Node]*yaml3.Node{})
+	}
+	annotate(u.n, d.o)
+	return &u
+}
+
+// annotate sets the line comments of the tree of node n to the origins in
+// origin tree o.
+func annotate(n *yaml3.Node, o interface{}) {
+	switch {
+	case n.Kind == yaml3.DocumentNode && len(n.Content) > 0:
+		annotate(n.Content[0], o)
+		return
+	case n.Kind == yaml3.AliasNode:
+		return
+	case n.Style&yaml3.FlowStyle != 0 && (n.Kind == yaml3.MappingNode || n.Kind == yaml3.SequenceNode):
+		seen, ss := map[string]bool{}, []string{}
+		leaves(o, func(v interface{}) interface{} {
+			if ov, ok := v.(Origin); ok && ov != (Origin{}) && !seen[ov.String()] {
+				seen[ov.String()] = true
+				ss = append(ss, ov.String())
+			}
+			return v
+		})
+		sort.Strings(ss)
+		if len(ss) > 0 {
+			comment(n, strings.Join(ss, ", "))
+		}
+		return
+	}
+	switch n.Kind {
+	case yaml3.MappingNode:
+		om, _ := o.(map[string]interface{})
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			if k := n.Content[i]; !isMerge(k) && k.Kind == yaml3.ScalarNode {
+				annotate(n.Content[i+1], om[k.Value])
+			}
+		}
+	case yaml3.SequenceNode:
+		os, _ := o.([]interface{})
+		for i, c := range n.Content {
+			if i < len(os) {
+				annotate(c, os[i])
+			}
+		}
+	case yaml3.ScalarNode:
+		if ov, ok := o.(Origin); ok && ov != (Origin{}) {
+			comment(n, ov.String())
+		}
+	}
+}
+
+// comment adds s to the line comment of node n.
+func comment(n *yaml3.Node, s string) {
+	if n.LineComment != "" {
+		n.LineComment += " "
+	}
+	n.LineComment += "# " + s
+}
+
+// copyNode returns a deep copy of the tree of node n, aliases refer to the
+// copies of their anchors.
+func copyNode(n *yaml3.Node, seen map[*yaml3.Node]*yaml3.Node) *yaml3.Node {
+	if c, ok := seen[n]; ok {
+		return c
+	}
+	c := *n
+	seen[n] = &c
+	c.Content = make([]*yaml3.Node, len(n.Content))
+	for i, u := range n.Content {
+		c.Content[i] = copyNode(u, seen)
+	}
+	if n.Alias != nil {
+		c.Alias = copyNode(n.Alias, seen)
+	}
+	return &c
+}
+
+// Origin returns the origin of the leaf at json pointer path of the
+// document's value, for a Doc with origins, see Decoder.TrackOrigins.
+func (d *Doc) Origin(path string) (Origin, bool) {
+	return originAt(d.o, path)
+}
@@ -0,0 +1,142 @@
+package jam
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestOrigin(t *testing.T) {
+	var ss = []struct {
+		name string
+		ins  []string
+		path string
+		x    Origin
+		ok   bool
+	}{
+		{"yaml", []string{"a: 1\nb:\n  c: 2\n"}, "/b/c", Origin{"0", 3}, true},
+		{"yaml list", []string{"a:\n  - 1\n  - 2\n"}, "/a/1", Origin{"0", 3}, true},
+		{"yaml merge key", []string{"x: &x\n  k: 1\ny:\n  <<: *x\n"}, "/y/k", Origin{"0", 2}, true},
+		{"yaml document", []string{"a: 1\n---\n\nb: 2\n"}, "/b", Origin{"0", 4}, true},
+		{"json", []string{"{\n  \"a\": {\n    \"b\": 1\n  }\n}\n"}, "/a/b", Origin{"0", 3}, true},
+		{"json list", []string{"[1,\n 2]\n"}, "/1", Origin{"0", 2}, true},
+		{"toml", []string{"[a]\nb = 1\n"}, "/a/b", Origin{"0", 0}, true},
+		{"merged", []string{"a: 1\nb: 2\n", "b: 3\n"}, "/b", Origin{"1", 1}, true},
+		{"merged kept", []string{"a: 1\nb: 2\n", "b: 3\n"}, "/a", Origin{"0", 1}, true},
+		{"merged list", []string{"a: [1, 2]\n", "a: [3]\n"}, "/a/1", Origin{"0", 1}, true},
+		{"map", []string{"a:\n  b: 1\n"}, "/a", Origin{}, false},
+		{"missing", []string{"a: 1\n"}, "/b", Origin{}, false},
+		{"bad pointer", []string{"a: 1\n"}, "a", Origin{}, false},
+	}
+	for _, s := range ss {
+		t.Run(s.name, func(t *testing.T) {
+			j := NewJam()
+			for i, in := range s.ins {
+				d := NewDecoder(strings.NewReader(in)).TrackOrigins()
+				d.ds[0].name = strconv.Itoa(i)
+				for {
+					var doc Doc
+					err := d.Decode(&doc)
+					if IsNoMore(err) {
+						break
+					}
+					if err != nil {
+						t.Fatal(err)
+					}
+					j.Merge(&doc)
+				}
+			}
+			o, ok := j.Origin(0, s.path)
+			if o != s.x || ok != s.ok {
+				t.Errorf("expected %v %v, got %v %v", s.x, s.ok, o, ok)
+			}
+		})
+	}
+}
+
+func TestOriginDecoder(t *testing.T) {
+	var d Doc
+	err := NewDecoder(strings.NewReader("a: 1\nb: 2\n"), strings.NewReader("{\"b\": 3}")).TrackOrigins().Decode(&d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for p, x := range map[string]Origin{"/a": {"0", 1}, "/b": {"1", 1}} {
+		if o, ok := d.Origin(p); !ok || o != x {
+			t.Errorf("%s: expected %v, got %v %v", p, x, o, ok)
+		}
+	}
+}
+
+func TestOriginDropped(t *testing.T) {
+	var d Doc
+	if err := NewDecoder(strings.NewReader("a: 1\n")).TrackOrigins().Decode(&d); err != nil {
+		t.Fatal(err)
+	}
+	j := NewJam(&d)
+	if _, ok := j.Origin(0, "/a"); !ok {
+		t.Fatal("expected an origin")
+	}
+	j.Query("a")
+	if _, ok := j.Origin(0, ""); ok {
+		t.Error("expected no origin after a query")
+	}
+}
+
+func TestAnnotated(t *testing.T) {
+	var ss = []struct {
+		name string
+		ins  []string
+		x    string
+	}{
+		{
+			"yaml",
+			[]string{"# a\na: 1 # one\nb:\n  c: x\n  d: [1, 2]\n", "b:\n  c: y\ne: true\n"},
+			"---\n# a\na: 1 # one # 0:2\nb:\n  c: y # 1:2\n  d: [1, 2] # 0:5\ne: true # 1:3\n",
+		},
+		{
+			"comments",
+			[]string{"a: 1 # one\nb: [1, 2] # two\nc:\n  - x # three\n", "a: 2 # four\n"},
+			"---\na: 2 # four # 1:1\nb: [1, 2] # two # 0:2\nc:\n  - x # three # 0:4\n",
+		},
+		{
+			"json",
+			[]string{"{\"a\": 1,\n\"b\": [2]}"},
+			"---\na: 1 # 0:1\nb:\n  - 2 # 0:2\n",
+		},
+		{
+			"alias",
+			[]string{"x: &x 1\ny: *x\n"},
+			"---\nx: &x 1 # 0:1\ny: *x\n",
+		},
+	}
+	for _, s := range ss {
+		t.Run(s.name, func(t *testing.T) {
+			j := NewJam()
+			for i, in := range s.ins {
+				d := NewDecoder(strings.NewReader(in)).TrackOrigins()
+				d.ds[0].name = strconv.Itoa(i)
+				var doc Doc
+				if err := d.Decode(&doc); err != nil {
+					t.Fatal(err)
+				}
+				j.Merge(&doc)
+			}
+			d := j.Doc(0)
+			var bb bytes.Buffer
+			if err := NewEncoder(&bb).Encode(d.Annotated()); err != nil {
+				t.Fatal(err)
+			}
+			if bb.String() != s.x {
+				t.Errorf("expected\n%s\ngot\n%s", s.x, bb.String())
+			}
+			bb.Reset()
+			if err := NewEncoder(&bb).Encode(d); err != nil {
+				t.Fatal(err)
+			}
+			if strings.Contains(bb.String(), "# 0:") {
+				t.Errorf("expected the doc to be unchanged, got\n%s", bb.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,421 @@
+package jam
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Op is an operation of a json patch (RFC 6902). Op is one of add, remove,
+// replace, move, copy and test. Path and From are json pointers (RFC 6901),
+// From is for move and copy, Value for add, replace and test.
+type Op struct {
+	Op    string
+	Path  string
+	From  string
+	Value interface{}
+}
+
+// MarshalJSON writes the op as a json object, with from and value only for
+// the ops that have them.
+func (o Op) MarshalJSON() ([]byte, error) {
+	return json.Marshal(o.tree())
+}
+
+// UnmarshalJSON reads the op from a json object, see ParsePatch.
+func (o *Op) UnmarshalJSON(b []byte) error {
+	jd := json.NewDecoder(bytes.NewReader(b))
+	jd.UseNumber()
+	v, err := decodeJson(jd)
+	if err != nil {
+		return err
+	}
+	op, err := parseOp(v)
+	if err != nil {
+		return err
+	}
+	*o = op
+	return nil
+}
+
+// tree returns the op as a *Map.
+func (o Op) tree() *Map {
+	m := NewMap()
+	m.Set("op", o.Op)
+	m.Set("path", o.Path)
+	switch o.Op {
+	case "move", "copy":
+		m.Set("from", o.From)
+	case "add", "replace", "test":
+		m.Set("value", o.Value)
+	}
+	return m
+}
+
+// Patch outputs the json patch (RFC 6902) that changes a into b. List items
+// are matched like Changes does, and are removed, moved, added or patched.
+// The values of the ops are new trees.
+func Patch(a, b interface{}) []Op {
+	return PatchWith(a, b, MergeOptions{})
+}
+
+// PatchWith outputs the json patch that changes a into b like Patch. The
+// items of lists that opts merge by key match by key, other options are
+// ignored.
+func PatchWith(a, b interface{}, opts MergeOptions) []Op {
+	return merger(opts).patch(a, b, "", nil, []Op{})
+}
+
+// patch appends the ops that change a into b at pointer p to ops, path is
+// the map keys to a.
+func (m merger) patch(a, b interface{}, p string, path []string, ops []Op) []Op {
+	if equal(a, b) {
+		return ops
+	}
+	if ma, mb, ok := maps(a, b); ok {
+		for _, k := range ma.ks {
+			if _, ok := mb.m[k]; !ok {
+				ops = append(ops, Op{Op: "remove", Path: p + "/" + escape(k)})
+			}
+		}
+		for _, k := range mb.ks {
+			if u, ok := ma.m[k]; ok {
+				ops = m.patch(u, mb.m[k], p+"/"+escape(k), append(path[:len(path):len(path)], k), ops)
+				continue
+			}
+			ops = append(ops, Op{Op: "add", Path: p + "/" + escape(k), Value: Clone(mb.m[k])})
+		}
+		return ops
+	}
+	sa, aok := a.([]interface{})
+	sb, bok := b.([]interface{})
+	if !aok || !bok {
+		return append(ops, Op{Op: "replace", Path: p, Value: Clone(b)})
+	}
+	ps := match(sa, sb, m.key(path))
+	mv, is, bs := moved(ps), map[int]int{}, map[int]int{}
+	for _, q := range ps {
+		is[q.b], bs[q.a] = q.a, q.b
+	}
+	for i := len(sa) - 1; i >= 0; i-- {
+		if _, ok := bs[i]; !ok {
+			ops = append(ops, Op{Op: "remove", Path: p + "/" + strconv.Itoa(i)})
+		}
+	}
+	// the items as they are, by their index in b
+	cur := []int{}
+	for i := range sa {
+		if j, ok := bs[i]; ok {
+			cur = append(cur, j)
+		}
+	}
+	find := func(j int) int {
+		for k, u := range cur {
+			if u == j {
+				return k
+			}
+		}
+		return -1
+	}
+	// the items of b are put in turn after the one before them, the items
+	// that stay are in order already
+	for j, v := range sb {
+		k := find(j-1) + 1
+		i, ok := is[j]
+		switch {
+		case !ok:
+			pk := p + "/" + strconv.Itoa(k)
+			if k == len(cur) {
+				pk = p + "/-"
+			}
+			ops = append(ops, Op{Op: "add", Path: pk, Value: Clone(v)})
+			cur = append(cur[:k:k], append([]int{j}, cur[k:]...)...)
+			continue
+		case mv[j]:
+			f := find(j)
+			cur = append(cur[:f:f], cur[f+1:]...)
+			k = find(j-1) + 1
+			ops = append(ops, Op{Op: "move", From: p + "/" + strconv.Itoa(f), Path: p + "/" + strconv.Itoa(k)})
+			cur = append(cur[:k:k], append([]int{j}, cur[k:]...)...)
+		default:
+			k = find(j)
+		}
+		ops = m.patch(sa[i], v, p+"/"+strconv.Itoa(k), path, ops)
+	}
+	return ops
+}
+
+// ApplyPatch outputs v changed by the ops of json patch (RFC 6902) patch, in
+// turn. The patch is applied to a copy of v, which is unchanged. An op that
+// fails, a test included, fails the patch.
+func ApplyPatch(v interface{}, patch []Op) (interface{}, error) {
+	v = Clone(v)
+	for i, op := range patch {
+		var err error
+		if v, err = apply(v, op); err != nil {
+			return nil, fmt.Errorf("op %d, %s %s: %s", i, op.Op, op.Path, err)
+		}
+	}
+	return v, nil
+}
+
+// apply outputs v changed by op, v is changed in place.
+func apply(v interface{}, op Op) (interface{}, error) {
+	ts, err := pointer(op.Path)
+	if err != nil {
+		return nil, err
+	}
+	switch op.Op {
+	case "add":
+		return add(v, ts, Clone(op.Value))
+	case "remove":
+		return remove(v, ts)
+	case "replace":
+		if _, err := get(v, ts); err != nil {
+			return nil, err
+		}
+		if v, err = remove(v, ts); err != nil {
+			return nil, err
+		}
+		return add(v, ts, Clone(op.Value))
+	case "move", "copy":
+		fs, err := pointer(op.From)
+		if err != nil {
+			return nil, err
+		}
+		u, err := get(v, fs)
+		if err != nil {
+			return nil, err
+		}
+		if op.Op == "copy" {
+			return add(v, ts, Clone(u))
+		}
+		if strings.HasPrefix(op.Path, op.From+"/") {
+			return nil, errors.New("can not move a value into itself")
+		}
+		if v, err = remove(v, fs); err != nil {
+			return nil, err
+		}
+		return add(v, ts, u)
+	case "test":
+		u, err := get(v, ts)
+		if err != nil {
+			return nil, err
+		}
+		if !equal(u, op.Value) {
+			return nil, errors.New("test failed")
+		}
+		return v, nil
+	}
+	return nil, fmt.Errorf("unknown op %q", op.Op)
+}
+
+// get returns the value at pointer tokens ts of v.
+func get(v interface{}, ts []string) (interface{}, error) {
+	for _, t := range ts {
+		if m, ok := toMap(v); ok {
+			u, ok := m.m[t]
+			if !ok {
+				return nil, fmt.Errorf("no key %q", t)
+			}
+			v = u
+			continue
+		}
+		s, ok := v.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("no key %q in a value that is not a map or a list", t)
+		}
+		i, err := index(t, len(s)-1)
+		if err != nil {
+			return nil, err
+		}
+		v = s[i]
+	}
+	return v, nil
+}
+
+// add outputs v with x added at pointer tokens ts. A key that is set is
+// replaced, an index is inserted at, "-" appends.
+func add(v interface{}, ts []string, x interface{}) (interface{}, error) {
+	if len(ts) == 0 {
+		return x, nil
+	}
+	return update(v, ts, func(v interface{}, t string) (interface{}, error) {
+		if m, ok := v.(*Map); ok {
+			m.Set(t, x)
+			return m, nil
+		}
+		if m, ok := v.(map[string]interface{}); ok {
+			m[t] = x
+			return m, nil
+		}
+		s := v.([]interface{})
+		if t == "-" {
+			return append(s, x), nil
+		}
+		i, err := index(t, len(s))
+		if err != nil {
+			return nil, err
+		}
+		return append(s[:i:i], append([]interface{}{x}, s[i:]...)...), nil
+	})
+}
+
+// remove outputs v without the value at pointer tokens ts, which must be
+// set. Removing the root leaves nil.
+func remove(v interface{}, ts []string) (interface{}, error) {
+	if len(ts) == 0 {
+		return nil, nil
+	}
+	return update(v, ts, func(v interface{}, t string) (interface{}, error) {
+		if m, ok := toMap(v); ok {
+			if _, ok := m.m[t]; !ok {
+				return nil, fmt.Errorf("no key %q", t)
+			}
+			m.Delete(t)
+			return v, nil
+		}
+		s := v.([]interface{})
+		i, err := index(t, len(s)-1)
+		if err != nil {
+			return nil, err
+		}
+		return append(s[:i:i], s[i+1:]...), nil
+	})
+}
+
+// update outputs v with the map or list at pointer tokens ts[:len(ts)-1]
+// replaced by fn of it and the last token.
+func update(v interface{}, ts []string, fn func(interface{}, string) (interface{}, error)) (interface{}, error) {
+	t := ts[0]
+	if len(ts) == 1 {
+		if _, ok := v.([]interface{}); !ok && !isMap(v) {
+			return nil, fmt.Errorf("no key %q in a value that is not a map or a list", t)
+		}
+		return fn(v, t)
+	}
+	u, err := get(v, ts[:1])
+	if err != nil {
+		return nil, err
+	}
+	if u, err = update(u, ts[1:], fn); err != nil {
+		return nil, err
+	}
+	if m, ok := toMap(v); ok {
+		m.m[t] = u
+		return v, nil
+	}
+	s := v.([]interface{})
+	i, _ := index(t, len(s)-1)
+	s[i] = u
+	return s, nil
+}
+
+// index returns list index t, which may be at most max.
+func index(t string, max int) (int, error) {
+	i, err := strconv.Atoi(t)
+	if err != nil || strings.Trim(t, "0123456789") != "" || len(t) > 1 && t[0] == '0' {
+		return 0, fmt.Errorf("bad list index %q", t)
+	}
+	if i > max {
+		return 0, fmt.Errorf("list index %d out of range", i)
+	}
+	return i, nil
+}
+
+// pointer splits json pointer p into its reference tokens, unescaped.
+func pointer(p string) ([]string, error) {
+	if p == "" {
+		return nil, nil
+	}
+	if p[0] != '/' {
+		return nil, fmt.Errorf("bad json pointer %q", p)
+	}
+	ts := strings.Split(p[1:], "/")
+	for i, t := range ts {
+		ts[i] = strings.NewReplacer("~1", "/", "~0", "~").Replace(t)
+	}
+	return ts, nil
+}
+
+// escape escapes key k for a json pointer.
+func escape(k string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(k)
+}
+
+// ParsePatch reads a json patch from a decoded tree, a list of maps each
+// with an op, a path, and a from or a value as the op needs.
+func ParsePatch(v interface{}) ([]Op, error) {
+	if d, ok := v.(*Doc); ok {
+		v = d.v
+	}
+	s, ok := v.([]interface{})
+	if !ok {
+		return nil, errors.New("a patch is a list of ops")
+	}
+	ops := make([]Op, len(s))
+	for i, u := range s {
+		op, err := parseOp(u)
+		if err != nil {
+			return nil, fmt.Errorf("op %d: %s", i, err)
+		}
+		ops[i] = op
+	}
+	return ops, nil
+}
+
+// parseOp reads an op from a decoded map.
+func parseOp(v interface{}) (Op, error) {
+	m, ok := toMap(v)
+	if !ok {
+		return Op{}, errors.New("an op is a map")
+	}
+	str := func(k string) (string, error) {
+		u, ok := m.m[k]
+		if !ok {
+			return "", fmt.Errorf("no %s", k)
+		}
+		s, ok := u.(string)
+		if !ok {
+			return "", fmt.Errorf("%s is not a string", k)
+		}
+		return s, nil
+	}
+	var (
+		op  Op
+		err error
+	)
+	if op.Op, err = str("op"); err != nil {
+		return Op{}, err
+	}
+	if op.Path, err = str("path"); err != nil {
+		return Op{}, err
+	}
+	switch op.Op {
+	case "remove":
+	case "move", "copy":
+		if op.From, err = str("from"); err != nil {
+			return Op{}, err
+		}
+	case "add", "replace", "test":
+		if op.Value, ok = m.m["value"]; !ok {
+			return Op{}, errors.New("no value")
+		}
+	default:
+		return Op{}, fmt.Errorf("unknown op %q", op.Op)
+	}
+	return op, nil
+}
+
+// PatchTree returns json patch patch as a tree, a list of *Map, for an
+// Encoder.
+func PatchTree(patch []Op) interface{} {
+	s := make([]interface{}, len(patch))
+	for i, op := range patch {
+		s[i] = op.tree()
+	}
+	return s
+}
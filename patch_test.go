@@ -0,0 +1,197 @@
+package jam
+
+import (
+	"encoding/json"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+func TestPatch(t *testing.T) {
+	var ss = []struct {
+		a, b interface{}
+		x    []Op
+	}{
+		{_m{"a": 1.0}, _m{"a": 1.0}, []Op{}},
+		{_m{"a": 1.0, "b": 2.0}, _m{"a": 3.0, "c": nil}, []Op{
+			{Op: "remove", Path: "/b"},
+			{Op: "replace", Path: "/a", Value: 3.0},
+			{Op: "add", Path: "/c", Value: nil},
+		}},
+		{_m{"a/b": _m{"c~d": 1.0}}, _m{"a/b": _m{"c~d": 2.0}}, []Op{
+			{Op: "replace", Path: "/a~1b/c~0d", Value: 2.0},
+		}},
+		{_s{1.0, 2.0, 3.0}, _s{1.0, 4.0}, []Op{
+			{Op: "remove", Path: "/2"},
+			{Op: "replace", Path: "/1", Value: 4.0},
+		}},
+		{_s{1.0}, _s{1.0, _m{"a": 1.0}, 2.0}, []Op{
+			{Op: "add", Path: "/-", Value: _m{"a": 1.0}},
+			{Op: "add", Path: "/-", Value: 2.0},
+		}},
+		{_m{"a": 1.0}, _s{1.0}, []Op{{Op: "replace", Path: "", Value: _s{1.0}}}},
+		{_s{"x", 1.0, 2.0}, _s{0.0, 1.0, 2.0, "x"}, []Op{
+			{Op: "add", Path: "/0", Value: 0.0},
+			{Op: "move", From: "/1", Path: "/3"},
+		}},
+		{_s{"a", "b", "c"}, _s{"c", "a", "b"}, []Op{
+			{Op: "move", From: "/2", Path: "/0"},
+		}},
+		{_s{1.0, 2.0, 3.0}, _s{0.0, 1.0, 2.0, 3.0}, []Op{
+			{Op: "add", Path: "/0", Value: 0.0},
+		}},
+	}
+	for _, s := range ss {
+		p := Patch(s.a, s.b)
+		if !reflect.DeepEqual(p, s.x) {
+			t.Errorf("%v to %v: expected %v, got %v", s.a, s.b, s.x, p)
+			continue
+		}
+		v, err := ApplyPatch(s.a, p)
+		if err != nil {
+			t.Errorf("%v to %v: %s", s.a, s.b, err)
+			continue
+		}
+		if !equal(v, s.b) {
+			t.Errorf("%v patched: expected %v, got %v", s.a, s.b, v)
+		}
+	}
+}
+
+func TestPatchLists(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	list := func() []interface{} {
+		s := make([]interface{}, r.Intn(8))
+		for i := range s {
+			s[i] = float64(r.Intn(6))
+			if r.Intn(4) == 0 {
+				s[i] = _m{"id": float64(r.Intn(6)), "v": float64(r.Intn(2))}
+			}
+		}
+		return s
+	}
+	opts := MergeOptions{Paths: map[string]ListStrategy{"k": {Merge: ListKey, Key: "id"}}}
+	for n := 0; n < 500; n++ {
+		a, b := _m{"l": list(), "k": list()}, _m{"l": list(), "k": list()}
+		v, err := ApplyPatch(a, PatchWith(a, b, opts))
+		if err != nil {
+			t.Fatalf("%v to %v: %s", a, b, err)
+		}
+		if !equal(v, b) {
+			t.Fatalf("%v to %v: got %v", a, b, v)
+		}
+	}
+}
+
+func TestPatchKeyed(t *testing.T) {
+	a := _m{"c": _s{_m{"name": "a", "v": 1.0}, _m{"name": "b", "v": 2.0}}}
+	b := _m{"c": _s{_m{"name": "b", "v": 3.0}, _m{"name": "a", "v": 1.0}}}
+	opts := MergeOptions{Paths: map[string]ListStrategy{"c": {Merge: ListKey, Key: "name"}}}
+	x := []Op{
+		{Op: "replace", Path: "/c/1/v", Value: 3.0},
+		{Op: "move", From: "/c/0", Path: "/c/1"},
+	}
+	if p := PatchWith(a, b, opts); !reflect.DeepEqual(p, x) {
+		t.Errorf("expected %v, got %v", x, p)
+	}
+}
+
+func TestApplyPatch(t *testing.T) {
+	// examples of RFC 6902, appendix A
+	var ss = []struct {
+		v, p, x string
+	}{
+		{`{"foo":"bar"}`, `[{"op":"add","path":"/baz","value":"qux"}]`, `{"baz":"qux","foo":"bar"}`},
+		{`{"foo":["bar","baz"]}`, `[{"op":"add","path":"/foo/1","value":"qux"}]`, `{"foo":["bar","qux","baz"]}`},
+		{`{"baz":"qux","foo":"bar"}`, `[{"op":"remove","path":"/baz"}]`, `{"foo":"bar"}`},
+		{`{"foo":["bar","qux","baz"]}`, `[{"op":"remove","path":"/foo/1"}]`, `{"foo":["bar","baz"]}`},
+		{`{"baz":"qux","foo":"bar"}`, `[{"op":"replace","path":"/baz","value":"boo"}]`, `{"baz":"boo","foo":"bar"}`},
+		{`{"foo":{"bar":"baz","waldo":"fred"},"qux":{"corge":"grault"}}`, `[{"op":"move","from":"/foo/waldo","path":"/qux/thud"}]`, `{"foo":{"bar":"baz"},"qux":{"corge":"grault","thud":"fred"}}`},
+		{`{"foo":["all","grass","cows","eat"]}`, `[{"op":"move","from":"/foo/1","path":"/foo/3"}]`, `{"foo":["all","cows","eat","grass"]}`},
+		{`{"baz":"qux","foo":["a",2,"c"]}`, `[{"op":"test","path":"/baz","value":"qux"},{"op":"test","path":"/foo/1","value":2}]`, `{"baz":"qux","foo":["a",2,"c"]}`},
+		{`{"foo":"bar"}`, `[{"op":"add","path":"/child","value":{"grandchild":{}}}]`, `{"child":{"grandchild":{}},"foo":"bar"}`},
+		{`{"foo":"bar"}`, `[{"op":"add","path":"/baz","value":"qux","xyz":123}]`, `{"baz":"qux","foo":"bar"}`},
+		{`{"foo":["bar"]}`, `[{"op":"add","path":"/foo/-","value":["abc","def"]}]`, `{"foo":["bar",["abc","def"]]}`},
+		{`{"/":9,"~1":10}`, `[{"op":"test","path":"/~01","value":10}]`, `{"/":9,"~1":10}`},
+		{`{"a":{"b":1}}`, `[{"op":"copy","from":"/a","path":"/c"},{"op":"replace","path":"/c/b","value":2}]`, `{"a":{"b":1},"c":{"b":2}}`},
+		{`{"a":1}`, `[{"op":"replace","path":"","value":[1]}]`, `[1]`},
+	}
+	for _, s := range ss {
+		var v interface{}
+		var p []Op
+		if err := json.Unmarshal([]byte(s.v), &v); err != nil {
+			t.Fatal(err)
+		}
+		if err := json.Unmarshal([]byte(s.p), &p); err != nil {
+			t.Fatal(err)
+		}
+		o, err := ApplyPatch(v, p)
+		if err != nil {
+			t.Errorf("%s: %s", s.p, err)
+			continue
+		}
+		b, err := json.Marshal(o)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != s.x {
+			t.Errorf("%s: expected %s, got %s", s.p, s.x, b)
+		}
+	}
+}
+
+func TestApplyPatchError(t *testing.T) {
+	v := _m{"a": _s{1.0}, "b": 2.0}
+	var ss = []string{
+		`[{"op":"remove","path":"/c"}]`,
+		`[{"op":"replace","path":"/c","value":1}]`,
+		`[{"op":"add","path":"/c/d","value":1}]`,
+		`[{"op":"add","path":"/a/2","value":1}]`,
+		`[{"op":"add","path":"/a/01","value":1}]`,
+		`[{"op":"remove","path":"/a/1"}]`,
+		`[{"op":"add","path":"/b/c","value":1}]`,
+		`[{"op":"test","path":"/b","value":3}]`,
+		`[{"op":"move","from":"/a","path":"/a/0"}]`,
+		`[{"op":"add","path":"a","value":1}]`,
+	}
+	for _, s := range ss {
+		var p []Op
+		if err := json.Unmarshal([]byte(s), &p); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ApplyPatch(v, p); err == nil {
+			t.Errorf("%s: expected an error", s)
+		}
+	}
+	if x := (_m{"a": _s{1.0}, "b": 2.0}); !reflect.DeepEqual(v, x) {
+		t.Errorf("expected %v unchanged, got %v", x, v)
+	}
+}
+
+func TestParsePatch(t *testing.T) {
+	var ss = []struct {
+		v  interface{}
+		ok bool
+	}{
+		{_s{om("op", "add", "path", "/a", "value", nil)}, true},
+		{_s{om("op", "move", "path", "/a", "from", "/b")}, true},
+		{_s{om("op", "add", "path", "/a")}, false},
+		{_s{om("op", "move", "path", "/a")}, false},
+		{_s{om("op", "nope", "path", "/a")}, false},
+		{_s{om("path", "/a")}, false},
+		{_s{1.0}, false},
+		{_m{"op": "remove"}, false},
+	}
+	for _, s := range ss {
+		if _, err := ParsePatch(s.v); (err == nil) != s.ok {
+			t.Errorf("%v: expected ok %v, got %v", s.v, s.ok, err)
+		}
+	}
+	b, err := json.Marshal(PatchTree([]Op{{Op: "add", Path: "/a", Value: nil}, {Op: "remove", Path: "/b", Value: 1}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if x := `[{"op":"add","path":"/a","value":null},{"op":"remove","path":"/b"}]`; string(b) != x {
+		t.Errorf("expected %s, got %s", x, b)
+	}
+}
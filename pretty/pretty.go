@@ -10,6 +10,7 @@ import (
 	"github.com/alecthomas/chroma/lexers"
 	lexg "github.com/alecthomas/chroma/lexers/g"
 	lexj "github.com/alecthomas/chroma/lexers/j"
+	lexx "github.com/alecthomas/chroma/lexers/x"
 	"github.com/alecthomas/chroma/styles"
 )
 
@@ -57,6 +58,28 @@ func (b *Buffer) Toml() {
 	})
 }
 
+// Xml makes prettified Xml for terminal display
+func (b *Buffer) Xml() {
+	b.appendf(func(w io.Writer, s string) error {
+		t, err := lexx.XML.Tokenise(nil, s)
+		if err != nil {
+			return err
+		}
+		return formatters.TTY16m.Format(w, chromaStyle, t)
+	})
+}
+
+// Diff makes prettified change lines for terminal display
+func (b *Buffer) Diff() {
+	b.appendf(func(w io.Writer, s string) error {
+		t, err := diffLexer.Tokenise(nil, s)
+		if err != nil {
+			return err
+		}
+		return formatters.TTY16m.Format(w, diffStyle, t)
+	})
+}
+
 const (
 	pink      = "#ffafc7"
 	blue      = "#74d7ec"
@@ -86,6 +109,27 @@ var goStyle = styles.Register(chroma.MustNewStyle("jamgo", map[chroma.TokenType]
 	chroma.Error:       pink,
 }))
 
+var diffStyle = styles.Register(chroma.MustNewStyle("jamdiff", map[chroma.TokenType]string{
+	chroma.GenericInserted: blue,
+	chroma.GenericDeleted:  pink,
+	chroma.GenericEmph:     white,
+	chroma.GenericHeading:  lightBlue,
+}))
+
+var diffLexer = lexers.Register(chroma.MustNewLexer(
+	&chroma.Config{
+		Name: "jamdiff",
+	},
+	chroma.Rules{
+		"root": {
+			{`\+.*\n?`, chroma.GenericInserted, nil},
+			{`-.*\n?`, chroma.GenericDeleted, nil},
+			{`>.*\n?`, chroma.GenericHeading, nil},
+			{`.*\n?`, chroma.GenericEmph, nil},
+		},
+	},
+))
+
 var yamlLexer = lexers.Register(chroma.MustNewLexer(
 	&chroma.Config{
 		Name: "jamyaml",
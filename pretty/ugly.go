@@ -5,4 +5,6 @@ package pretty
 func (b *Buffer) Yaml() {}
 func (b *Buffer) Json() {}
 func (b *Buffer) Toml() {}
+func (b *Buffer) Xml()  {}
 func (b *Buffer) Go()   {}
+func (b *Buffer) Diff() {}
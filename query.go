@@ -0,0 +1,131 @@
+package jam
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	jmespath "github.com/jmespath/go-jmespath"
+)
+
+// QueryExpr is a compiled jmespath query, which searches any number of
+// values. See CompileQuery.
+type QueryExpr struct {
+	query string
+	jp    *jmespath.JMESPath
+}
+
+// QueryError is a jmespath query that does not compile, or that fails on a
+// value. Column is where a syntax error was found in Query, counting from 1,
+// or 0 for an error on a value.
+type QueryError struct {
+	Query  string
+	Column int
+	Msg    string
+}
+
+func (e *QueryError) Error() string {
+	if e.Column == 0 {
+		return fmt.Sprintf("query %q: %s", e.Query, e.Msg)
+	}
+	return fmt.Sprintf("query %q: %d: %s", e.Query, e.Column, e.Msg)
+}
+
+// CompileQuery parses a jmespath query once, for searches of many values. A
+// query that is not valid is a *QueryError. See http://jmespath.org/
+func CompileQuery(query string) (*QueryExpr, error) {
+	jp, err := jmespath.Compile(query)
+	if se, ok := err.(jmespath.SyntaxError); ok {
+		return nil, &QueryError{query, se.Offset + 1, strings.TrimPrefix(se.Error(), "SyntaxError: ")}
+	}
+	if err != nil {
+		return nil, &QueryError{Query: query, Msg: err.Error()}
+	}
+	return &QueryExpr{query: query, jp: jp}, nil
+}
+
+// String returns the query.
+func (e *QueryExpr) String() string {
+	return e.query
+}
+
+// Query searches v, see Query. A search that fails, a function given an
+// argument of the wrong type for one, is a *QueryError.
+func (e *QueryExpr) Query(v interface{}) (interface{}, error) {
+	v = leaves(plain(v), text)
+	x, err := e.jp.Search(leaves(v, float))
+	if err != nil {
+		return nil, &QueryError{Query: e.query, Msg: err.Error()}
+	}
+	// search again with each input number as a tag, a made up number in
+	// the same order, so the numbers of the result that are tags come from
+	// the input, and the others are computed
+	var ns []interface{}
+	leaves(v, func(v interface{}) interface{} {
+		switch v.(type) {
+		case json.Number, float64:
+			ns = append(ns, v)
+		}
+		return v
+	})
+	sort.SliceStable(ns, func(i, j int) bool {
+		return float(ns[i]).(float64) < float(ns[j]).(float64)
+	})
+	tags, ts := map[interface{}]float64{}, map[float64]interface{}{}
+	for _, n := range ns {
+		if _, ok := tags[n]; !ok {
+			t := float64(len(tags)) + 0.5
+			tags[n], ts[t] = t, n
+		}
+	}
+	y, err := e.jp.Search(leaves(v, func(v interface{}) interface{} {
+		if t, ok := tags[v]; ok {
+			return t
+		}
+		return v
+	}))
+	if err != nil {
+		return x, nil
+	}
+	return sourced(x, y, ts), nil
+}
+
+// sourced returns x, the result of a search, with each number that is a tag
+// in y, the same search of tags, and that is equal to the tagged input
+// number, as that input number
+func sourced(x, y interface{}, ts map[float64]interface{}) interface{} {
+	switch x := x.(type) {
+	case float64:
+		if t, ok := y.(float64); ok && ts[t] != nil && float(ts[t]) == x {
+			return ts[t]
+		}
+	case map[string]interface{}:
+		if y, ok := y.(map[string]interface{}); ok {
+			m := make(map[string]interface{}, len(x))
+			for k, u := range x {
+				m[k] = sourced(u, y[k], ts)
+			}
+			return m
+		}
+	case []interface{}:
+		if y, ok := y.([]interface{}); ok && len(y) == len(x) {
+			s := make([]interface{}, len(x))
+			for i, u := range x {
+				s[i] = sourced(u, y[i], ts)
+			}
+			return s
+		}
+	}
+	return x
+}
+
+// QueryE applies a jmespath search to v like Query, and returns the error of
+// a query that does not compile or fails, a *QueryError.
+func QueryE(v interface{}, s string) (interface{}, error) {
+	e, err := CompileQuery(s)
+	if err != nil {
+		return nil, err
+	}
+	return e.Query(v)
+}
@@ -0,0 +1,91 @@
+package jam
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestCompileQuery(t *testing.T) {
+	var ss = []struct {
+		query  string
+		column int
+	}{
+		{"a.b[0]", 0},
+		{"items[?replicas > `1`].name | [0]", 0},
+		{"a[", 3},
+		{"a.", 3},
+		{"a ||", 5},
+		{"[?a ==]", 7},
+	}
+	for _, s := range ss {
+		_, err := CompileQuery(s.query)
+		var qe *QueryError
+		switch {
+		case s.column == 0 && err != nil:
+			t.Errorf("%q: expected no error, got %s", s.query, err)
+		case s.column == 0:
+		case !errors.As(err, &qe):
+			t.Errorf("%q: expected a QueryError, got %v", s.query, err)
+		case qe.Column != s.column || qe.Query != s.query:
+			t.Errorf("%q: expected column %d, got %s", s.query, s.column, err)
+		}
+	}
+}
+
+func TestQueryE(t *testing.T) {
+	var ss = []struct {
+		v     interface{}
+		query string
+		x     interface{}
+		err   bool
+	}{
+		{_m{"a": _s{1.0, 2.0}}, "a[1]", 2.0, false},
+		{_m{"a": 1}, "b", nil, false},
+		{_m{"a": "x"}, "abs(a)", nil, true},
+		{_m{"a": 1}, "a[", nil, true},
+		{_m{"a": 1}, "nope(a)", nil, true},
+		{_m{"a": json.Number("12345678901234567891")}, "a", json.Number("12345678901234567891"), false},
+		{_m{"a": _s{json.Number("2.50"), json.Number("1")}}, "max(a)", json.Number("2.50"), false},
+		{_m{"a": _s{json.Number("2.50"), json.Number("1")}}, "sum(a)", 3.5, false},
+		{_m{"a": _s{json.Number("1.0"), json.Number("1")}}, "a[0]", json.Number("1.0"), false},
+		{_m{"a": _s{json.Number("1.0"), json.Number("1")}}, "a[1]", json.Number("1"), false},
+		{_m{"a": _s{json.Number("1e2"), json.Number("2")}}, "length(a[1:])", 1.0, false},
+		{_m{"a": json.Number("2.0"), "b": _s{1, 2}}, "length(b)", 2.0, false},
+		{_m{"a": _s{json.Number("1.0"), json.Number("3")}}, "sort(a)[0]", json.Number("1.0"), false},
+		{_m{"a": _s{_m{"n": json.Number("1e2"), "b": true}}}, "a[?b].n", _s{json.Number("1e2")}, false},
+	}
+	for _, s := range ss {
+		v, err := QueryE(s.v, s.query)
+		if !reflect.DeepEqual(v, s.x) || (err != nil) != s.err {
+			t.Errorf("%q: expected %v %v, got %v %v", s.query, s.x, s.err, v, err)
+		}
+		var qe *QueryError
+		if err != nil && !errors.As(err, &qe) {
+			t.Errorf("%q: expected a QueryError, got %v", s.query, err)
+		}
+		if v := Query(s.v, s.query); !reflect.DeepEqual(v, s.x) {
+			t.Errorf("%q: expected %v, got %v", s.query, s.x, v)
+		}
+	}
+}
+
+func TestJamQueryError(t *testing.T) {
+	j := NewJam(_m{"a": 1.0}, _m{"a": "x"})
+	for _, q := range []string{"a[", "abs(a)"} {
+		if err := j.Query(q); err == nil {
+			t.Errorf("%q: expected an error", q)
+		}
+		if !reflect.DeepEqual(j.Value(0), _m{"a": 1.0}) || !reflect.DeepEqual(j.Value(1), _m{"a": "x"}) {
+			t.Errorf("%q: expected the values to be unchanged, got %v %v", q, j.Value(0), j.Value(1))
+		}
+	}
+	e, err := CompileQuery("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := j.Search(e); err != nil || j.Value(0) != 1.0 || j.Value(1) != "x" {
+		t.Errorf("expected 1 x, got %v %v %v", j.Value(0), j.Value(1), err)
+	}
+}
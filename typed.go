@@ -0,0 +1,228 @@
+package jam
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Typed scalars in the generic data tree. Datetimes with a time zone are a
+// time.Time, dates, times and datetimes without one are a LocalDate,
+// LocalTime or LocalDateTime, and binary values are a []byte. They come
+// from toml and yaml, and are written natively to toml, yaml and go syntax.
+// Other encodings write them as text, binary values in base64.
+
+// LocalDate is a date without a time zone, like 1979-05-27.
+type LocalDate string
+
+// LocalTime is a time of day without a time zone, like 07:32:00.
+type LocalTime string
+
+// LocalDateTime is a date and time without a time zone, like
+// 1979-05-27T07:32:00.
+type LocalDateTime string
+
+const (
+	localDate     = "2006-01-02"
+	localTime     = "15:04:05.999999999"
+	localDateTime = localDate + "T" + localTime
+)
+
+// MarshalTOML writes the date as a toml local date.
+func (d LocalDate) MarshalTOML() ([]byte, error) { return []byte(d), nil }
+
+// MarshalTOML writes the time as a toml local time.
+func (t LocalTime) MarshalTOML() ([]byte, error) { return []byte(t), nil }
+
+// MarshalTOML writes the datetime as a toml local datetime.
+func (t LocalDateTime) MarshalTOML() ([]byte, error) { return []byte(t), nil }
+
+func (d LocalDate) GoString() string     { return fmt.Sprintf("jam.LocalDate(%q)", string(d)) }
+func (t LocalTime) GoString() string     { return fmt.Sprintf("jam.LocalTime(%q)", string(t)) }
+func (t LocalDateTime) GoString() string { return fmt.Sprintf("jam.LocalDateTime(%q)", string(t)) }
+
+// goTime is a time.Time written as go syntax.
+type goTime time.Time
+
+func (t goTime) GoString() string {
+	u := time.Time(t)
+	_, off := u.Zone()
+	loc := fmt.Sprintf("time.FixedZone(\"\", %d)", off)
+	if off == 0 {
+		loc = "time.UTC"
+	}
+	return fmt.Sprintf("time.Date(%d, time.%s, %d, %d, %d, %d, %d, %s)",
+		u.Year(), u.Month(), u.Day(), u.Hour(), u.Minute(), u.Second(), u.Nanosecond(), loc)
+}
+
+// goBytes is a []byte written as go syntax.
+type goBytes []byte
+
+func (b goBytes) GoString() string { return fmt.Sprintf("[]byte(%q)", string(b)) }
+
+// goNumber is a number that no go number holds, written as go syntax.
+type goNumber json.Number
+
+func (n goNumber) GoString() string { return fmt.Sprintf("json.Number(%q)", string(n)) }
+
+// goValue returns typed scalars and numbers as values that are written as go
+// syntax, other values as they are. A number that an int64, uint64 or
+// float64 would change is a json.Number.
+func goValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case time.Time:
+		return goTime(v)
+	case []byte:
+		return goBytes(v)
+	case json.Number:
+		if u, ok := exactly(v); ok {
+			return u
+		}
+		return goNumber(v)
+	}
+	return v
+}
+
+// local returns t as a local type when toml decoded it without a time zone,
+// toml marks those by the name of their location.
+func local(t time.Time) interface{} {
+	switch t.Location().String() {
+	case "date-local":
+		return LocalDate(t.Format(localDate))
+	case "time-local":
+		return LocalTime(t.Format(localTime))
+	case "datetime-local":
+		return LocalDateTime(t.Format(localDateTime))
+	}
+	return t
+}
+
+// yamlZoneRe matches the time zone at the end of a yaml timestamp.
+var yamlZoneRe = regexp.MustCompile(`(?:[Zz]|[+-]\d\d?(?::?\d\d)?)$`)
+
+// timestamp returns yaml timestamp s, parsed as t, as a typed scalar. A date
+// without a time is a LocalDate and a timestamp without a time zone is a
+// LocalDateTime, yaml takes them to be UTC.
+func timestamp(s string, t time.Time) interface{} {
+	s = strings.TrimSpace(s)
+	switch {
+	case !strings.ContainsAny(s, "Tt :"):
+		return LocalDate(t.Format(localDate))
+	case !yamlZoneRe.MatchString(s):
+		return LocalDateTime(t.Format(localDateTime))
+	}
+	return t
+}
+
+// tree returns u as a value of the generic data tree, maps, lists, strings,
+// bools, nil, numbers and typed scalars. Numbers are json.Number when numbers
+// is true, float64 otherwise. Values of other types are converted by way of
+// json.
+func tree(u interface{}, numbers bool) (interface{}, error) {
+	switch u := u.(type) {
+	case nil, bool, string, []byte, LocalDate, LocalTime, LocalDateTime, deletion:
+		return u, nil
+	case time.Time:
+		return local(u), nil
+	case json.Number:
+		if numbers {
+			return u, nil
+		}
+		return u.Float64()
+	case float64:
+		if !numbers {
+			return u, nil
+		}
+		b, err := json.Marshal(u)
+		return json.Number(b), err
+	case int:
+		return integer(strconv.FormatInt(int64(u), 10), float64(u), numbers), nil
+	case int64:
+		return integer(strconv.FormatInt(u, 10), float64(u), numbers), nil
+	case uint64:
+		return integer(strconv.FormatUint(u, 10), float64(u), numbers), nil
+	case *Map:
+		m := &Map{ks: u.Keys(), m: make(map[string]interface{}, len(u.ks))}
+		for k, v := range u.m {
+			v, err := tree(v, numbers)
+			if err != nil {
+				return nil, err
+			}
+			m.m[k] = v
+		}
+		return m, nil
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(u))
+		for k, v := range u {
+			v, err := tree(v, numbers)
+			if err != nil {
+				return nil, err
+			}
+			m[k] = v
+		}
+		return m, nil
+	case []interface{}:
+		s := make([]interface{}, len(u))
+		for i, v := range u {
+			v, err := tree(v, numbers)
+			if err != nil {
+				return nil, err
+			}
+			s[i] = v
+		}
+		return s, nil
+	case []map[string]interface{}:
+		s := make([]interface{}, len(u))
+		for i, v := range u {
+			v, err := tree(v, numbers)
+			if err != nil {
+				return nil, err
+			}
+			s[i] = v
+		}
+		return s, nil
+	}
+
+	b, err := json.Marshal(u)
+	if err != nil {
+		return nil, err
+	}
+	var v interface{}
+	jd := json.NewDecoder(strings.NewReader(string(b)))
+	if numbers {
+		jd.UseNumber()
+	}
+	return v, jd.Decode(&v)
+}
+
+// integer returns an integer as json.Number s when numbers is true, as
+// float64 f otherwise.
+func integer(s string, f float64, numbers bool) interface{} {
+	if numbers {
+		return json.Number(s)
+	}
+	return f
+}
+
+// text returns typed scalars as text, other values as they are.
+func text(v interface{}) interface{} {
+	switch v := v.(type) {
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	case []byte:
+		return base64.StdEncoding.EncodeToString(v)
+	case LocalDate:
+		return string(v)
+	case LocalTime:
+		return string(v)
+	case LocalDateTime:
+		return string(v)
+	case deletion:
+		return string(v)
+	}
+	return v
+}
@@ -0,0 +1,243 @@
+package jam
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Xml is mapped onto the generic data tree as follows.
+//
+// The document element becomes a map with a single key, the element name.
+// An element with neither attributes nor child elements is its text, a
+// string. Any other element is a map. Attributes are keys prefixed with "@",
+// child elements are keys by name, and non-blank text is kept under "#text".
+// Repeated sibling elements with the same name become a list in document
+// order. Names keep their namespace prefix as written, "soap:Body" for
+// example, and namespace declarations are attributes like any other.
+// Comments, processing instructions and directives are discarded.
+//
+// Encoding reverses the mapping. A map with a single key, which is not an
+// attribute or text and whose value is not a list, is encoded as the
+// document element, anything else is wrapped in a "jam" element. The items
+// of a list wrapped so are elements named "jam" too. Names that are not
+// valid xml names are an error.
+const (
+	xmlAttr = "@"
+	xmlText = "#text"
+	xmlRoot = "jam"
+)
+
+// xmlElem is an element under construction.
+type xmlElem struct {
+	name string
+	m    *Map
+	text bytes.Buffer
+}
+
+// add puts a child value into the element, repeated names become a list.
+// Element values are never lists, so a list can only come from repetition.
+func (e *xmlElem) add(k string, v interface{}) {
+	u, ok := e.m.Get(k)
+	switch u := u.(type) {
+	case []interface{}:
+		e.m.Set(k, append(u, v))
+	default:
+		if ok {
+			e.m.Set(k, []interface{}{u, v})
+			return
+		}
+		e.m.Set(k, v)
+	}
+}
+
+// value returns the element as a value in the generic data tree.
+func (e *xmlElem) value() interface{} {
+	text := e.text.String()
+	if e.m.Len() == 0 {
+		return text
+	}
+	if strings.TrimSpace(text) != "" {
+		e.m.Set(xmlText, text)
+	}
+	return e.m
+}
+
+// decodeXml decodes one xml document from b.
+func decodeXml(b []byte) (interface{}, error) {
+	d := xml.NewDecoder(bytes.NewReader(b))
+	var (
+		stack []*xmlElem
+		root  = &xmlElem{m: NewMap()}
+	)
+	for {
+		t, err := d.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch t := t.(type) {
+		case xml.StartElement:
+			if len(stack) == 0 && root.m.Len() > 0 {
+				return nil, &xml.SyntaxError{Msg: "more than one document element", Line: xmlLine(b, d.InputOffset())}
+			}
+			e := &xmlElem{name: xmlName(t.Name), m: NewMap()}
+			for _, a := range t.Attr {
+				e.m.Set(xmlAttr+xmlName(a.Name), a.Value)
+			}
+			stack = append(stack, e)
+		case xml.EndElement:
+			line := xmlLine(b, d.InputOffset())
+			if len(stack) == 0 {
+				return nil, &xml.SyntaxError{Msg: "unexpected end element </" + xmlName(t.Name) + ">", Line: line}
+			}
+			e := stack[len(stack)-1]
+			if n := xmlName(t.Name); n != e.name {
+				return nil, &xml.SyntaxError{Msg: "element <" + e.name + "> closed by </" + n + ">", Line: line}
+			}
+			stack = stack[:len(stack)-1]
+			parent := root
+			if len(stack) > 0 {
+				parent = stack[len(stack)-1]
+			}
+			parent.add(e.name, e.value())
+		case xml.CharData:
+			if len(stack) > 0 {
+				stack[len(stack)-1].text.Write(t)
+			}
+		}
+	}
+	if len(stack) > 0 {
+		return nil, &xml.SyntaxError{
+			Msg:  "unexpected EOF, element <" + stack[len(stack)-1].name + "> is not closed",
+			Line: xmlLine(b, int64(len(b))),
+		}
+	}
+	if root.m.Len() == 0 {
+		return nil, nil
+	}
+	return root.value(), nil
+}
+
+// xmlLine returns the line number at offset off in b.
+func xmlLine(b []byte, off int64) int {
+	return bytes.Count(b[:off], []byte("\n")) + 1
+}
+
+// xmlName returns a name as written, with its namespace prefix.
+func xmlName(n xml.Name) string {
+	if n.Space == "" {
+		return n.Local
+	}
+	return n.Space + ":" + n.Local
+}
+
+// asXml writes xml to w.
+func asXml(w io.Writer, v interface{}) error {
+	k, u := xmlRoot, v
+	if m, ok := toMap(v); ok && m.Len() == 1 {
+		_, list := m.m[m.ks[0]].([]interface{})
+		if n := m.ks[0]; !list && n != xmlText && !strings.HasPrefix(n, xmlAttr) {
+			k, u = n, m.m[n]
+		}
+	}
+	if _, ok := u.([]interface{}); ok {
+		m := NewMap()
+		m.Set(xmlRoot, u)
+		u = m
+	}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	e := xml.NewEncoder(w)
+	e.Indent("", "  ")
+	if err := xmlEncode(e, k, u); err != nil {
+		return err
+	}
+	if err := e.Flush(); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// xmlEncode writes v as one or more elements named k.
+func xmlEncode(e *xml.Encoder, k string, v interface{}) error {
+	if s, ok := v.([]interface{}); ok {
+		for _, v := range s {
+			if err := xmlEncode(e, k, v); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if !isXmlName(k) {
+		return fmt.Errorf("%q is not a valid xml element name", k)
+	}
+	start := xml.StartElement{Name: xml.Name{Local: k}}
+	m, ok := toMap(v)
+	if !ok {
+		if err := e.EncodeToken(start); err != nil {
+			return err
+		}
+		if v != nil {
+			if err := e.EncodeToken(xml.CharData(scalar(v))); err != nil {
+				return err
+			}
+		}
+		return e.EncodeToken(start.End())
+	}
+
+	for _, k := range m.ks {
+		if strings.HasPrefix(k, xmlAttr) {
+			if !isXmlName(k[len(xmlAttr):]) {
+				return fmt.Errorf("%q is not a valid xml attribute name", k[len(xmlAttr):])
+			}
+			start.Attr = append(start.Attr, xml.Attr{
+				Name:  xml.Name{Local: k[len(xmlAttr):]},
+				Value: scalar(m.m[k]),
+			})
+		}
+	}
+	if err := e.EncodeToken(start); err != nil {
+		return err
+	}
+	if t, ok := m.Get(xmlText); ok {
+		if err := e.EncodeToken(xml.CharData(scalar(t))); err != nil {
+			return err
+		}
+	}
+	for _, k := range m.ks {
+		if k == xmlText || strings.HasPrefix(k, xmlAttr) {
+			continue
+		}
+		if err := xmlEncode(e, k, m.m[k]); err != nil {
+			return err
+		}
+	}
+	return e.EncodeToken(start.End())
+}
+
+// isXmlName reports whether s is a name by the xml spec, namespace prefix
+// included.
+func isXmlName(s string) bool {
+	for i, r := range s {
+		switch {
+		case r == ':' || r == '_' || 'A' <= r && r <= 'Z' || 'a' <= r && r <= 'z',
+			0xc0 <= r && r <= 0xd6, 0xd8 <= r && r <= 0xf6, 0xf8 <= r && r <= 0x2ff,
+			0x370 <= r && r <= 0x37d, 0x37f <= r && r <= 0x1fff, 0x200c <= r && r <= 0x200d,
+			0x2070 <= r && r <= 0x218f, 0x2c00 <= r && r <= 0x2fef, 0x3001 <= r && r <= 0xd7ff,
+			0xf900 <= r && r <= 0xfdcf, 0xfdf0 <= r && r <= 0xfffd, 0x10000 <= r && r <= 0xeffff:
+		case i > 0 && (r == '-' || r == '.' || '0' <= r && r <= '9' || r == 0xb7),
+			i > 0 && (0x300 <= r && r <= 0x36f || 0x203f <= r && r <= 0x2040):
+		default:
+			return false
+		}
+	}
+	return s != ""
+}
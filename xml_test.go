@@ -0,0 +1,149 @@
+package jam
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestXmlDecode(t *testing.T) {
+	var ss = []struct {
+		i string
+		x interface{}
+	}{
+		{"<a>blep</a>", _m{"a": "blep"}},
+		{"<a/>", _m{"a": ""}},
+		{"<?xml version=\"1.0\"?>\n<a>blep</a>", _m{"a": "blep"}},
+		{"<a x=\"1\">blep</a>", _m{"a": _m{"@x": "1", "#text": "blep"}}},
+		{"<a>\n  <b>blep</b>\n  <c>mlem</c>\n</a>", _m{"a": _m{"b": "blep", "c": "mlem"}}},
+		{"<a><b>1</b><b>2</b><b>3</b></a>", _m{"a": _m{"b": _s{"1", "2", "3"}}}},
+		{"<a><b x=\"1\"/><b>2</b></a>", _m{"a": _m{"b": _s{_m{"@x": "1"}, "2"}}}},
+		{"<!-- hi -->\n<a><!-- blep -->mlem</a>", _m{"a": "mlem"}},
+		{
+			`<soap:Envelope xmlns:soap="http://www.w3.org/2003/05/soap-envelope"><soap:Body/></soap:Envelope>`,
+			_m{"soap:Envelope": _m{
+				"@xmlns:soap": "http://www.w3.org/2003/05/soap-envelope",
+				"soap:Body":   "",
+			}},
+		},
+	}
+	for _, s := range ss {
+		var v interface{}
+		if err := NewDecoder(strings.NewReader(s.i)).Decode(&v); err != nil {
+			t.Errorf("%q: %s", s.i, err)
+			continue
+		}
+		if !reflect.DeepEqual(v, s.x) {
+			t.Errorf("%q: expected %#v, got %#v", s.i, s.x, v)
+		}
+	}
+}
+
+func TestXmlDecodeFail(t *testing.T) {
+	ss := []string{
+		"<a>",
+		"<a></b>",
+		"<a></a><b></b>",
+		"<a x=1></a>",
+	}
+	for _, s := range ss {
+		var v interface{}
+		if err := NewDecoder(strings.NewReader(s)).Decode(&v); err == nil {
+			t.Errorf("%q: expected error got none", s)
+		}
+	}
+}
+
+func TestXmlEncode(t *testing.T) {
+	var ss = []struct {
+		i interface{}
+		x string
+	}{
+		{_m{"a": "blep"}, "<a>blep</a>"},
+		{_m{"a": nil}, "<a></a>"},
+		{_m{"a": 1.5}, "<a>1.5</a>"},
+		{"blep", "<jam>blep</jam>"},
+		{_m{"a": 1, "b": 2}, "<jam>\n  <a>1</a>\n  <b>2</b>\n</jam>"},
+		{_m{"a": _m{"@x": "1", "#text": "blep"}}, "<a x=\"1\">blep</a>"},
+		{_m{"a": _m{"b": _s{"1", "2"}}}, "<a>\n  <b>1</b>\n  <b>2</b>\n</a>"},
+		{_m{"a": "<&>"}, "<a>&lt;&amp;&gt;</a>"},
+		{_s{1, 2}, "<jam>\n  <jam>1</jam>\n  <jam>2</jam>\n</jam>"},
+		{_m{"a": _s{1, 2}}, "<jam>\n  <a>1</a>\n  <a>2</a>\n</jam>"},
+		{_m{"a": _s{}}, "<jam></jam>"},
+		{_m{"@x": "1"}, "<jam x=\"1\"></jam>"},
+		{_m{"é.1": "x"}, "<é.1>x</é.1>"},
+	}
+	for _, s := range ss {
+		var bb bytes.Buffer
+		if err := NewEncoder(&bb).AsXml().Encode(s.i); err != nil {
+			t.Error(err)
+		}
+		x := "<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n" + s.x + "\n"
+		if bb.String() != x {
+			t.Errorf("%v: expected\n%s\ngot\n%s", s.i, x, bb.String())
+		}
+	}
+}
+
+func TestXmlEncodeFail(t *testing.T) {
+	var ss = []interface{}{
+		_m{"a b": 1},
+		_m{"1a": 1},
+		_m{"": 1},
+		_m{"a": _m{"@x y": "1"}},
+		_m{"a": 1, "b<": 2},
+	}
+	for _, s := range ss {
+		var bb bytes.Buffer
+		if err := NewEncoder(&bb).AsXml().Encode(s); err == nil {
+			t.Errorf("%v: expected an error, got\n%s", s, bb.String())
+		}
+	}
+}
+
+func TestXmlRoundTrip(t *testing.T) {
+	var ss = []interface{}{
+		_m{"a": "blep"},
+		_m{"a": _m{"b": "blep", "c": _s{"1", "2"}}},
+		_m{"soap:Envelope": _m{
+			"@xmlns:soap": "http://www.w3.org/2003/05/soap-envelope",
+			"soap:Body":   _m{"m:Price": _m{"@xmlns:m": "urn:x", "#text": "34.5"}},
+		}},
+	}
+	for _, s := range ss {
+		var bb bytes.Buffer
+		if err := NewEncoder(&bb).AsXml().Encode(s); err != nil {
+			t.Error(err)
+		}
+		var v interface{}
+		if err := NewDecoder(&bb).Decode(&v); err != nil {
+			t.Error(err)
+		}
+		if !reflect.DeepEqual(s, v) {
+			t.Errorf("xml: expected %#v, got %#v", s, v)
+		}
+	}
+
+	var ws = []struct {
+		i, x interface{}
+	}{
+		{_s{"1", "2"}, _m{"jam": _m{"jam": _s{"1", "2"}}}},
+		{_m{"a": _s{"1", "2"}}, _m{"jam": _m{"a": _s{"1", "2"}}}},
+		{_m{"a": _s{}}, _m{"jam": ""}},
+		{_m{"@x": "1"}, _m{"jam": _m{"@x": "1"}}},
+	}
+	for _, s := range ws {
+		var bb bytes.Buffer
+		if err := NewEncoder(&bb).AsXml().Encode(s.i); err != nil {
+			t.Error(err)
+		}
+		var v interface{}
+		if err := NewDecoder(&bb).Decode(&v); err != nil {
+			t.Errorf("%v: %s", s.i, err)
+		}
+		if !reflect.DeepEqual(s.x, v) {
+			t.Errorf("%v: expected %#v, got %#v", s.i, s.x, v)
+		}
+	}
+}
@@ -0,0 +1,328 @@
+package jam
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	yaml3 "gopkg.in/yaml.v3"
+)
+
+// TagFunc handles a yaml tag. It is called with the tagged value, decoded
+// without the tag, a string for a tagged scalar. It returns the value to
+// use in its place.
+type TagFunc func(v interface{}) (interface{}, error)
+
+// EnvTag is a TagFunc for tags like "!env HOME" that are replaced by the
+// value of an environment variable.
+func EnvTag(v interface{}) (interface{}, error) {
+	s, ok := v.(string)
+	if !ok {
+		return nil, fmt.Errorf("env: expected a variable name, got %T", v)
+	}
+	return os.Getenv(s), nil
+}
+
+// FileTag is a TagFunc for tags like "!file path" that are replaced by the
+// content of a file, as a string.
+func FileTag(v interface{}) (interface{}, error) {
+	s, ok := v.(string)
+	if !ok {
+		return nil, fmt.Errorf("file: expected a path, got %T", v)
+	}
+	b, err := ioutil.ReadFile(s)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+// nodeErr is an error at a yaml node.
+type nodeErr struct {
+	r   ref
+	err error
+}
+
+func (e *nodeErr) Error() string { return e.err.Error() }
+func (e *nodeErr) Unwrap() error { return e.err }
+func (e *nodeErr) at() ref       { return e.r }
+
+// tagger resolves a yaml node tree with anchors, aliases, merge keys and
+// tags. Custom tags are resolved with the registered tag funcs. Nodes
+// resolved by way of an alias are counted against a budget.
+type tagger struct {
+	tags  map[string]TagFunc
+	seen  map[*yaml3.Node]bool
+	b     *budget
+	alias bool
+}
+
+// newTagger returns a tagger with tag funcs tags and a new budget.
+func newTagger(tags map[string]TagFunc) tagger {
+	return tagger{tags: tags, seen: map[*yaml3.Node]bool{}, b: &budget{}}
+}
+
+// budget counts the nodes resolved, and those resolved by way of an alias,
+// to stop a small document of nested aliases from expanding without bound.
+// The ratio of aliased nodes allowed falls as the count grows, like yaml.v3
+// allows when it decodes values.
+type budget struct {
+	nodes, aliased int
+}
+
+// spend counts a node and reports whether the budget allows it.
+func (b *budget) spend(alias bool) bool {
+	b.nodes++
+	if alias {
+		b.aliased++
+	}
+	if b.aliased <= 100 || b.nodes <= 1000 {
+		return true
+	}
+	ratio := 0.99
+	switch {
+	case b.nodes >= 4000000:
+		ratio = 0.1
+	case b.nodes > 400000:
+		ratio = 0.99 - 0.89*float64(b.nodes-400000)/float64(4000000-400000)
+	}
+	return float64(b.aliased)/float64(b.nodes) <= ratio
+}
+
+// decodeYaml decodes one yaml document from b resolving tags with tags. It
+// returns the value and the document node, which is nil for an empty document.
+func decodeYaml(b []byte, tags map[string]TagFunc) (interface{}, *yaml3.Node, error) {
+	var n yaml3.Node
+	if err := yaml3.Unmarshal(b, &n); err != nil {
+		return nil, nil, err
+	}
+	if n.Kind == 0 {
+		return nil, nil, nil
+	}
+	v, err := newTagger(tags).resolve(&n)
+	return v, &n, err
+}
+
+// fail returns an error at node n.
+func (t tagger) fail(n *yaml3.Node, format string, v ...interface{}) error {
+	return &nodeErr{ref{n.Line, n.Column}, fmt.Errorf(format, v...)}
+}
+
+// resolve returns the value of node n.
+func (t tagger) resolve(n *yaml3.Node) (interface{}, error) {
+	if t.seen[n] {
+		return nil, t.fail(n, "alias %s refers to itself", n.Value)
+	}
+	if !t.b.spend(t.alias) {
+		return nil, t.fail(n, "document contains excessive aliasing")
+	}
+	t.seen[n] = true
+	defer delete(t.seen, n)
+
+	if fn, tag := t.custom(n); tag != "" {
+		if fn == nil {
+			return nil, t.fail(n, "tag %s is not registered", tag)
+		}
+		var (
+			v   interface{}
+			err error
+		)
+		switch n.Kind {
+		case yaml3.ScalarNode:
+			v = n.Value
+		default:
+			u := *n
+			u.Tag = ""
+			if v, err = t.resolve(&u); err != nil {
+				return nil, err
+			}
+		}
+		v, err = fn(v)
+		if err != nil {
+			return nil, &nodeErr{ref{n.Line, n.Column}, fmt.Errorf("%s: %s", tag, err)}
+		}
+		return v, nil
+	}
+
+	switch n.Kind {
+	case yaml3.DocumentNode:
+		if len(n.Content) == 0 {
+			return nil, nil
+		}
+		return t.resolve(n.Content[0])
+	case yaml3.AliasNode:
+		t.alias = true
+		return t.resolve(n.Alias)
+	case yaml3.SequenceNode:
+		s := make([]interface{}, len(n.Content))
+		for i, c := range n.Content {
+			v, err := t.resolve(c)
+			if err != nil {
+				return nil, err
+			}
+			s[i] = v
+		}
+		return s, nil
+	case yaml3.MappingNode:
+		return t.mapping(n)
+	}
+	tag := n.ShortTag()
+	switch {
+	case (tag == "!!int" || tag == "!!float") && numberRe.MatchString(n.Value):
+		return json.Number(n.Value), nil
+	case tag == "!!binary":
+		b, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(n.Value), ""))
+		if err != nil {
+			return nil, &nodeErr{ref{n.Line, n.Column}, err}
+		}
+		return b, nil
+	}
+	var v interface{}
+	if err := n.Decode(&v); err != nil {
+		return nil, &nodeErr{ref{n.Line, n.Column}, err}
+	}
+	if ts, ok := v.(time.Time); ok && tag == "!!timestamp" {
+		return timestamp(n.Value, ts), nil
+	}
+	return v, nil
+}
+
+// custom returns the custom tag of n and its func, or an empty tag when n
+// has a standard tag. The tag !delete is Delete unless it has a func.
+func (t tagger) custom(n *yaml3.Node) (TagFunc, string) {
+	if len(n.Tag) < 2 || n.Tag[0] != '!' || n.Tag[1] == '!' {
+		return nil, ""
+	}
+	if fn, ok := t.tags[n.Tag]; ok || n.Tag != string(Delete) {
+		return fn, n.Tag
+	}
+	return deleteTag, n.Tag
+}
+
+// deleteTag is the TagFunc of !delete.
+func deleteTag(v interface{}) (interface{}, error) {
+	return Delete, nil
+}
+
+// mapping returns the map of mapping node n. Merge keys are applied where they
+// are, keys in the mapping itself take precedence, earlier merges take
+// precedence over later ones.
+func (t tagger) mapping(n *yaml3.Node) (interface{}, error) {
+	own := map[string]interface{}{}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		k, v := n.Content[i], n.Content[i+1]
+		if isMerge(k) {
+			continue
+		}
+		key, err := t.key(k)
+		if err != nil {
+			return nil, err
+		}
+		if own[key], err = t.resolve(v); err != nil {
+			return nil, err
+		}
+	}
+	m := NewMap()
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		k, v := n.Content[i], n.Content[i+1]
+		if !isMerge(k) {
+			key, _ := t.key(k)
+			m.Set(key, own[key])
+			continue
+		}
+		mt := t
+		for v.Kind == yaml3.AliasNode {
+			v, mt.alias = v.Alias, true
+		}
+		ms := []*yaml3.Node{v}
+		if v.Kind == yaml3.SequenceNode {
+			ms = v.Content
+		}
+		for _, u := range ms {
+			mv, err := mt.resolve(u)
+			if err != nil {
+				return nil, err
+			}
+			mm, ok := toMap(mv)
+			if !ok {
+				return nil, t.fail(u, "merge key value is not a map")
+			}
+			for _, k := range mm.ks {
+				if _, ok := own[k]; ok {
+					continue
+				}
+				if _, ok := m.Get(k); !ok {
+					m.Set(k, mm.m[k])
+				}
+			}
+		}
+	}
+	return m, nil
+}
+
+// isMerge reports whether node k is a merge key, "<<". The tag of a merge key
+// is cleared before encoding, which writes it with the tag otherwise.
+func isMerge(k *yaml3.Node) bool {
+	return k.Kind == yaml3.ScalarNode && k.Value == "<<" && k.Style == 0 && (k.Tag == "!!merge" || k.Tag == "")
+}
+
+// key returns the map key of node k.
+func (t tagger) key(k *yaml3.Node) (string, error) {
+	for k.Kind == yaml3.AliasNode {
+		k, t.alias = k.Alias, true
+	}
+	if k.Kind != yaml3.ScalarNode {
+		return "", t.fail(k, "map keys must be scalars")
+	}
+	v, err := t.resolve(k)
+	if err != nil {
+		return "", err
+	}
+	if v == nil {
+		return "null", nil
+	}
+	return fmt.Sprint(v), nil
+}
+
+// yamlNode returns a new node for v, which is decoded from json. Map keys are
+// in order, sorted for a map[string]interface{}, and numbers are written as
+// they are.
+func yamlNode(v interface{}) *yaml3.Node {
+	if m, ok := toMap(v); ok {
+		n := &yaml3.Node{Kind: yaml3.MappingNode, Tag: "!!map"}
+		for _, k := range m.ks {
+			n.Content = append(n.Content, yamlNode(k), yamlNode(m.m[k]))
+		}
+		return n
+	}
+	switch v := v.(type) {
+	case []interface{}:
+		n := &yaml3.Node{Kind: yaml3.SequenceNode, Tag: "!!seq"}
+		for _, u := range v {
+			n.Content = append(n.Content, yamlNode(u))
+		}
+		return n
+	case json.Number:
+		return &yaml3.Node{Kind: yaml3.ScalarNode, Value: string(v)}
+	case LocalDate:
+		return &yaml3.Node{Kind: yaml3.ScalarNode, Value: string(v)}
+	case LocalDateTime:
+		// yaml reads a timestamp without a time zone only with a space
+		return &yaml3.Node{Kind: yaml3.ScalarNode, Value: strings.Replace(string(v), "T", " ", 1)}
+	case LocalTime:
+		return yamlNode(string(v))
+	case []byte:
+		return &yaml3.Node{Kind: yaml3.ScalarNode, Tag: "!!binary", Value: base64.StdEncoding.EncodeToString(v)}
+	case deletion:
+		return &yaml3.Node{Kind: yaml3.ScalarNode, Tag: string(v)}
+	}
+	var n yaml3.Node
+	if err := n.Encode(v); err != nil {
+		n.Encode(fmt.Sprint(v))
+	}
+	return &n
+}
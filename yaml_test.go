@@ -0,0 +1,105 @@
+package jam
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func tagDecoder(s string) *Decoder {
+	up := func(v interface{}) (interface{}, error) {
+		s, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("expected a string, got %T", v)
+		}
+		return strings.ToUpper(s), nil
+	}
+	return NewDecoder(strings.NewReader(s)).Tag("!env", EnvTag).Tag("!up", up)
+}
+
+func TestYamlTags(t *testing.T) {
+	os.Setenv("JAM_TEST_TAG", "blep")
+	defer os.Unsetenv("JAM_TEST_TAG")
+
+	var ss = []struct {
+		i string
+		x interface{}
+	}{
+		{"a: !env JAM_TEST_TAG", _m{"a": "blep"}},
+		{"a: !up blep", _m{"a": "BLEP"}},
+		{"a: !!str 1", _m{"a": "1"}},
+		{"a: 1\ny: 2", _m{"a": 1.0, "y": 2.0}},
+		{"a: &x blep\nb: *x", _m{"a": "blep", "b": "blep"}},
+		{"a: &x [1, 2]\nb: *x", _m{"a": _s{1.0, 2.0}, "b": _s{1.0, 2.0}}},
+		{
+			"a: &x {b: 1, c: 2}\nd:\n  <<: *x\n  c: 3",
+			_m{"a": _m{"b": 1.0, "c": 2.0}, "d": _m{"b": 1.0, "c": 3.0}},
+		},
+		{
+			"a: &x {b: 1}\nc: &y {b: 2, d: 2}\ne: {<<: [*x, *y]}",
+			_m{"a": _m{"b": 1.0}, "c": _m{"b": 2.0, "d": 2.0}, "e": _m{"b": 1.0, "d": 2.0}},
+		},
+	}
+	for _, s := range ss {
+		var v interface{}
+		if err := tagDecoder(s.i).Decode(&v); err != nil {
+			t.Errorf("%q: %s", s.i, err)
+			continue
+		}
+		if !reflect.DeepEqual(v, s.x) {
+			t.Errorf("%q: expected %#v, got %#v", s.i, s.x, v)
+		}
+	}
+}
+
+func TestYamlTagsFail(t *testing.T) {
+	var ss = []struct {
+		i    string
+		l, c int
+		msg  string
+	}{
+		{"a: 1\nb: !nope 1", 2, 4, "tag !nope is not registered"},
+		{"a:\n  b: !up [1]", 2, 6, "!up: expected a string, got []interface {}"},
+		{"a: 1\nb: {<<: 1}", 2, 9, "merge key value is not a map"},
+	}
+	for _, s := range ss {
+		var v interface{}
+		err := tagDecoder(s.i).Decode(&v)
+		var e *DecodeError
+		if !errors.As(err, &e) {
+			t.Errorf("%q: expected a DecodeError, got %v", s.i, err)
+			continue
+		}
+		if e.Line != s.l || e.Column != s.c || !strings.HasSuffix(e.Error(), s.msg) {
+			t.Errorf("%q: expected %d:%d %s, got %s", s.i, s.l, s.c, s.msg, e)
+		}
+	}
+}
+
+func TestYamlAliases(t *testing.T) {
+	var bb strings.Builder
+	bb.WriteString("a: &a [x, x, x, x, x, x, x, x, x]\n")
+	for c := 'b'; c <= 'i'; c++ {
+		fmt.Fprintf(&bb, "%c: &%[1]c [*%c, *%[2]c, *%[2]c, *%[2]c, *%[2]c, *%[2]c, *%[2]c, *%[2]c, *%[2]c]\n", c, c-1)
+	}
+	for _, d := range []interface{}{new(interface{}), &Doc{}} {
+		err := NewDecoder(strings.NewReader(bb.String())).Decode(d)
+		var e *DecodeError
+		if !errors.As(err, &e) || !strings.HasSuffix(e.Error(), "document contains excessive aliasing") {
+			t.Errorf("%T: expected excessive aliasing, got %v", d, err)
+		}
+	}
+
+	bb.Reset()
+	bb.WriteString("a: &a [1, 2, 3]\nb:\n")
+	for i := 0; i < 2000; i++ {
+		bb.WriteString("  - *a\n")
+	}
+	var v interface{}
+	if err := NewDecoder(strings.NewReader(bb.String())).Decode(&v); err != nil {
+		t.Error(err)
+	}
+}